@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mnemonic is the opcode skeleton an assembler mnemonic fills in. Each
+// letter of args is one operand: x and y are registers placed in the second
+// and third nibble, a is a 12-bit address, b a byte and n a nibble.
+type mnemonic struct {
+	op   uint16
+	args string
+}
+
+// mnemonics uses the same names as the chip8asm assembler the test ROMs are
+// built with; they come from the opcode table.
+var mnemonics = func() map[string]mnemonic {
+	m := make(map[string]mnemonic)
+	for _, op := range opcodes {
+		if op.name != "" {
+			m[op.name] = mnemonic{op.match, op.args}
+		}
+	}
+	return m
+}()
+
+// assemble turns one line of assembly, e.g. "LOAD v1 0xAB", into an opcode.
+func assemble(line string) (uint16, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty line")
+	}
+	m, ok := mnemonics[strings.ToUpper(fields[0])]
+	if !ok {
+		return 0, fmt.Errorf("unknown mnemonic %q", fields[0])
+	}
+	args := fields[1:]
+	// A register-only form like "SHR v1" leaves y as zero.
+	if len(args) != len(m.args) && !(m.args == "xy" && len(args) == 1) {
+		return 0, fmt.Errorf("%s takes %d operands, got %d", fields[0], len(m.args), len(args))
+	}
+	op := m.op
+	for i, a := range args {
+		switch kind := m.args[i]; kind {
+		case 'x', 'y':
+			if len(a) < 2 || (a[0] != 'v' && a[0] != 'V') {
+				return 0, fmt.Errorf("bad register %q", a)
+			}
+			r, err := strconv.ParseUint(a[1:], 16, 4)
+			if err != nil {
+				return 0, fmt.Errorf("bad register %q", a)
+			}
+			if kind == 'x' {
+				op |= uint16(r) << 8
+			} else {
+				op |= uint16(r) << 4
+			}
+		default:
+			bits := map[byte]int{'a': 12, 'b': 8, 'n': 4}[kind]
+			v, err := strconv.ParseUint(a, 0, bits)
+			if err != nil {
+				return 0, fmt.Errorf("bad operand %q: %v", a, err)
+			}
+			op |= uint16(v)
+		}
+	}
+	return op, nil
+}
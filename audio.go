@@ -0,0 +1,100 @@
+package main
+
+import "math"
+
+const (
+	audioRate     = 44100 // samples per second from ReadAudio
+	buzzerHalf    = 50    // samples per half cycle, a 441Hz square wave
+	buzzerLevel   = 0.25
+	audioBuffered = 8 // frames of samples kept for a reader that falls behind
+)
+
+// audioRing holds the buzzer samples RunFrame has produced and ReadAudio
+// hasn't taken yet. When it's full the oldest samples are dropped.
+type audioRing struct {
+	buf   [audioBuffered * audioRate / 60]float32
+	start int
+	n     int
+	phase int     // samples into the current square wave cycle
+	bit   float64 // position in an XO-CHIP pattern, in pattern bits
+}
+
+// push adds a sample of the buzzer.
+func (a *audioRing) push(sounding bool) {
+	v := float32(0)
+	if sounding {
+		v = buzzerLevel
+		if a.phase >= buzzerHalf {
+			v = -buzzerLevel
+		}
+	}
+	a.phase = (a.phase + 1) % (2 * buzzerHalf)
+	a.add(v)
+}
+
+// pushPattern adds a sample of an XO-CHIP audio pattern played at rate bits
+// per second: each 1 bit is high and each 0 bit low, looping every 128.
+func (a *audioRing) pushPattern(sounding bool, pattern *[16]uint8, rate float64) {
+	v := float32(0)
+	if sounding {
+		i := int(a.bit)
+		v = -buzzerLevel
+		if pattern[i/8]&(0x80>>(i%8)) != 0 {
+			v = buzzerLevel
+		}
+		a.bit = math.Mod(a.bit+rate/audioRate, float64(8*len(pattern)))
+	}
+	a.add(v)
+}
+
+func (a *audioRing) add(v float32) {
+	if a.n == len(a.buf) {
+		a.start = (a.start + 1) % len(a.buf)
+		a.n--
+	}
+	a.buf[(a.start+a.n)%len(a.buf)] = v
+	a.n++
+}
+
+// frameAudio adds one frame of buzzer output, if anyone is reading it.
+func (c *Chip8) frameAudio() {
+	if c.audio == nil {
+		return
+	}
+	sounding := c.soundTimer > 0
+	if c.patterned {
+		rate := patternRate(c.pitch)
+		for i := 0; i < audioRate/60; i++ {
+			c.audio.pushPattern(sounding, &c.pattern, rate)
+		}
+		return
+	}
+	for i := 0; i < audioRate/60; i++ {
+		c.audio.push(sounding)
+	}
+}
+
+// patternRate is the XO-CHIP playback rate in bits per second for pitch:
+// 4000 at 64, doubling every 48.
+func patternRate(pitch uint8) float64 {
+	return 4000 * math.Pow(2, (float64(pitch)-64)/48)
+}
+
+// ReadAudio fills buf with buzzer output, mono at audioRate samples per
+// second, and returns how many samples it wrote. Every RunFrame produces a
+// sixtieth of a second; samples not read within audioBuffered frames are
+// dropped. Audio is only generated once ReadAudio has been called, so
+// embedders that don't want it pay nothing.
+func (c *Chip8) ReadAudio(buf []float32) int {
+	if c.audio == nil {
+		c.audio = new(audioRing)
+	}
+	a := c.audio
+	n := min(len(buf), a.n)
+	for i := 0; i < n; i++ {
+		buf[i] = a.buf[(a.start+i)%len(a.buf)]
+	}
+	a.start = (a.start + n) % len(a.buf)
+	a.n -= n
+	return n
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+)
+
+// stateParts names each piece of machine state and how to serialize it, so
+// two chips can be hashed and, when the hashes differ, compared piece by
+// piece.
+var stateParts = []struct {
+	name  string
+	bytes func(c *Chip8) []byte
+}{
+	{"registers", func(c *Chip8) []byte { return append(c.v[:], c.rpl[:]...) }},
+	{"pc", func(c *Chip8) []byte { return binary.BigEndian.AppendUint16(nil, c.pc) }},
+	{"index", func(c *Chip8) []byte { return binary.BigEndian.AppendUint16(nil, c.index) }},
+	{"timers", func(c *Chip8) []byte { return []byte{c.delayTimer, c.soundTimer} }},
+	{"stack", func(c *Chip8) []byte {
+		b := binary.BigEndian.AppendUint16(nil, c.sp)
+		for _, a := range c.stack {
+			b = binary.BigEndian.AppendUint16(b, a)
+		}
+		return b
+	}},
+	{"memory", func(c *Chip8) []byte { return c.memory }},
+	{"audio", func(c *Chip8) []byte {
+		b := []byte{c.pitch, 0}
+		if c.patterned {
+			b[1] = 1
+		}
+		return append(b, c.pattern[:]...)
+	}},
+	{"display", func(c *Chip8) []byte {
+		w, h := c.DisplaySize()
+		b := binary.BigEndian.AppendUint16(nil, uint16(w))
+		b = append(b, byte(h), c.planes, c.background)
+		b = append(b, c.gfx...)
+		for _, row := range c.zones {
+			b = append(b, row[:]...)
+		}
+		return b
+	}},
+	{"megachip", func(c *Chip8) []byte {
+		m := &c.mega
+		b := []byte{0, m.width, m.height, m.alpha, m.collide, m.indexHi}
+		if m.on {
+			b[0] = 1
+		}
+		for _, p := range m.palette {
+			b = append(b, p.R, p.G, p.B, p.A)
+		}
+		return append(b, m.shown...)
+	}},
+}
+
+// stateHash fingerprints everything a ROM can observe about the machine.
+func (c *Chip8) stateHash() uint64 {
+	h := fnv.New64a()
+	for _, p := range stateParts {
+		h.Write(p.bytes(c))
+	}
+	return h.Sum64()
+}
+
+// stateDiff lists the parts of the state that differ between a and b.
+func stateDiff(a, b *Chip8) []string {
+	var diff []string
+	for _, p := range stateParts {
+		if string(p.bytes(a)) != string(p.bytes(b)) {
+			diff = append(diff, p.name)
+		}
+	}
+	return diff
+}
+
+// auditROM runs rom on two chips side by side for up to frames frames, each
+// with a random source from newRand, and returns the first frame after
+// which their states differ along with what differs. frame is -1 if they
+// never did.
+func auditROM(rom []uint8, set settings, frames int, newRand func() randSource) (frame int, diff []string, err error) {
+	var chips [2]*Chip8
+	for i := range chips {
+		c := new(Chip8)
+		c.trace = io.Discard
+		c.rand = newRand()
+		c.Init()
+		if err := set.apply(c, new(frontendOpts)); err != nil {
+			return 0, nil, err
+		}
+		c.LoadROM(rom)
+		chips[i] = c
+	}
+	a, b := chips[0], chips[1]
+	for frame = 0; frame < frames; frame++ {
+		a.RunFrame()
+		b.RunFrame()
+		if a.stateHash() != b.stateHash() {
+			return frame, stateDiff(a, b), nil
+		}
+		if a.halted && b.halted || a.fault != nil && b.fault != nil {
+			break
+		}
+	}
+	return -1, nil, nil
+}
+
+// runAudit implements "hapax8 audit [flags] rom.ch8".
+func runAudit(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var set settings
+	set.register(fs)
+	frames := fs.Int("frames", 3600, "frames to run each copy for")
+	seed := fs.Int64("seed", 1, "seed for both copies' CXNN random numbers")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hapax8 audit [flags] rom.ch8")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("audit takes exactly one ROM")
+	}
+	rom, b, err := readROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		set = b.Settings
+		fs.Parse(args)
+	}
+	frame, diff, err := auditROM(rom, set, *frames, func() randSource {
+		return rand.New(rand.NewSource(*seed))
+	})
+	if err != nil {
+		return err
+	}
+	if frame >= 0 {
+		return fmt.Errorf("nondeterministic: runs diverged in frame %d (%v differ)", frame, diff)
+	}
+	fmt.Fprintln(stdout, "deterministic: both runs matched on every frame")
+	return nil
+}
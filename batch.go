@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// batchExts are the files "hapax8 batch" picks out of a directory as ROMs.
+// Files named on the command line are run whatever they are called.
+var batchExts = map[string]bool{
+	".ch8": true, ".c8": true, ".sc8": true, ".xo8": true, ".hc8": true, ".mc8": true, ".bin": true,
+	bundleExt: true,
+}
+
+// batchFlags are the flags "hapax8 batch" takes: the settings, which apply
+// to every ROM in the batch, and its own.
+type batchFlags struct {
+	fs       *flag.FlagSet
+	set      settings
+	frames   int
+	seed     int64
+	noDetect bool
+	report   string
+	shotOn   string
+}
+
+func newBatchFlags(stderr io.Writer) *batchFlags {
+	f := &batchFlags{fs: flag.NewFlagSet("batch", flag.ContinueOnError)}
+	f.fs.SetOutput(stderr)
+	f.set.register(f.fs)
+	f.fs.IntVar(&f.frames, "frames", 600, "frames to run each ROM for, unless it halts or faults first")
+	f.fs.Int64Var(&f.seed, "seed", 1, "seed for every ROM's CXNN random numbers")
+	f.fs.BoolVar(&f.noDetect, "no-detect", false, "don't scan each ROM for other variants' opcodes to pick -variant and its quirks")
+	f.fs.StringVar(&f.report, "opcode-report", "csv", "write which opcodes the ROMs ran, and how many ROMs ran each, as csv or json")
+	f.fs.StringVar(&f.shotOn, "shot-on", "off", "save a PNG of the display and a JSON dump of the state when a ROM stops: off, fault, or stop for halts too; they go in the current directory, named after the ROM")
+	return f
+}
+
+// opcodeUse is one line of the opcode report. Instructions that aren't in
+// the opcode table get a line each, under their own hex value.
+type opcodeUse struct {
+	Opcode      string `json:"opcode"`
+	Summary     string `json:"summary"`
+	ROMs        int    `json:"roms"`             // ROMs that ran it at least once
+	Executed    uint64 `json:"executed"`         // times it ran, over all of them
+	Unsupported int    `json:"unsupported_roms"` // ROMs where it was skipped or ignored rather than carried out
+}
+
+// batchRun runs one ROM of a batch for up to frames frames and tallies the
+// opcodes it ran into use. The settings are those given to the batch,
+// replaced by a bundle's or adjusted by variant detection as running the
+// ROM by itself would.
+func batchRun(path string, args []string, frames int, use map[string]*opcodeUse) error {
+	f := newBatchFlags(io.Discard)
+	f.fs.Parse(args) // already checked by runBatch
+	rom, b, err := readROM(path)
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		f.set = b.Settings
+		f.fs.Parse(args)
+	}
+	c := new(Chip8)
+	c.trace = io.Discard
+	c.rand = rand.New(rand.NewSource(f.seed))
+	c.Init()
+	if err := f.set.apply(c, new(frontendOpts)); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	c.LoadROM(rom)
+	if b == nil && !f.noDetect {
+		if v, _ := detectSettings(f.fs, c.memory[c.progStart:]); v != "" {
+			if err := f.set.apply(c, new(frontendOpts)); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			c.LoadROM(rom)
+		}
+	}
+	shot, _ := parseShotMode(f.shotOn) // also checked by runBatch
+	c.shot = newStopShot(shot, ".", path, rom, f.set)
+	c.opUse = make(map[*opcode]uint64)
+	for i := 0; i < frames && !c.halted && c.fault == nil; i++ {
+		c.RunFrame()
+	}
+
+	line := func(key, summary string) *opcodeUse {
+		u := use[key]
+		if u == nil {
+			u = &opcodeUse{Opcode: key, Summary: summary}
+			use[key] = u
+		}
+		return u
+	}
+	for op, n := range c.opUse {
+		u := line(op.pattern, op.summary)
+		u.ROMs++
+		u.Executed += n
+	}
+	skipped := map[*opcodeUse]bool{}
+	for inst, s := range c.unsupported {
+		op := lookupOpcode(inst)
+		if op == nil {
+			u := line(fmt.Sprintf("%04X", inst), "not an opcode")
+			u.ROMs++
+			u.Executed += s.count
+			u.Unsupported++
+			continue
+		}
+		skipped[line(op.pattern, op.summary)] = true
+	}
+	for u := range skipped {
+		u.Unsupported++
+	}
+	return nil
+}
+
+// batchPaths expands the directories among args into the ROMs in them.
+func batchPaths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() && batchExts[filepath.Ext(path)] {
+				paths = append(paths, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// writeOpcodeReport writes the report's lines, the opcodes the most ROMs
+// ran first, as CSV with a header row or as a JSON array.
+func writeOpcodeReport(w io.Writer, format string, use map[string]*opcodeUse) error {
+	lines := make([]*opcodeUse, 0, len(use))
+	for _, u := range use {
+		lines = append(lines, u)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.ROMs != b.ROMs {
+			return a.ROMs > b.ROMs
+		}
+		if a.Executed != b.Executed {
+			return a.Executed > b.Executed
+		}
+		return a.Opcode < b.Opcode
+	})
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(lines)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"opcode", "summary", "roms", "executed", "unsupported_roms"})
+		for _, u := range lines {
+			cw.Write([]string{u.Opcode, u.Summary, strconv.Itoa(u.ROMs), strconv.FormatUint(u.Executed, 10), strconv.Itoa(u.Unsupported)})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown opcode report format %q: use csv or json", format)
+}
+
+// runBatch implements "hapax8 batch [flags] rom-or-dir...": it runs every
+// ROM headless and reports which opcodes the corpus uses, so the ones
+// that matter most can be told from the ones nothing runs.
+func runBatch(args []string, stdout, stderr io.Writer) error {
+	f := newBatchFlags(stderr)
+	f.fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hapax8 batch [flags] rom-or-dir...")
+		f.fs.PrintDefaults()
+	}
+	if err := f.fs.Parse(args); err != nil {
+		return err
+	}
+	if f.fs.NArg() == 0 {
+		f.fs.Usage()
+		return errors.New("batch takes at least one ROM or directory")
+	}
+	if f.report != "csv" && f.report != "json" {
+		return fmt.Errorf("unknown opcode report format %q: use csv or json", f.report)
+	}
+	if _, err := parseShotMode(f.shotOn); err != nil {
+		return err
+	}
+	paths, err := batchPaths(f.fs.Args())
+	if err != nil {
+		return err
+	}
+	use := map[string]*opcodeUse{}
+	failed := 0
+	for _, path := range paths {
+		// One bad file shouldn't sink a corpus-wide report.
+		if err := batchRun(path, args, f.frames, use); err != nil {
+			fmt.Fprintln(stderr, err)
+			failed++
+		}
+	}
+	fmt.Fprintf(stderr, "ran %d of %d ROMs\n", len(paths)-failed, len(paths))
+	return writeOpcodeReport(stdout, f.report, use)
+}
@@ -0,0 +1,126 @@
+package main
+
+// pageSize is the granularity at which checkpoints share memory.
+const pageSize = 256
+
+// Checkpoint is a saved machine state for rewind and run-ahead. Registers
+// are copied in full; memory pages and the display are shared with the
+// previous checkpoint whenever they haven't been written since, so taking
+// one every frame stays cheap. The shared slices are never written to.
+type Checkpoint struct {
+	inst       uint16
+	v          [16]uint8
+	index      uint16
+	pc         uint16
+	delayTimer uint8
+	soundTimer uint8
+	stack      []uint16
+	sp         uint16
+	hires      bool
+	halted     bool
+	fault      error
+	jumpFrom   uint16
+	pages      [][]uint8
+	gfx        []uint8
+	planes     uint8
+	pattern    [16]uint8
+	patterned  bool
+	pitch      uint8
+	keyWait    keyWait
+	waiting    bool
+	vblank     bool
+	rpl        [16]uint8
+	zones      zoneGrid
+	background uint8
+
+	mega megaState // shown is copied
+}
+
+// markDirty records that addr changed since the last checkpoint.
+func (c *Chip8) markDirty(addr uint16) {
+	c.dirty[addr/pageSize] = true
+}
+
+// Checkpoint snapshots the machine.
+func (c *Chip8) Checkpoint() *Checkpoint {
+	cp := &Checkpoint{
+		inst:       c.inst,
+		v:          c.v,
+		index:      c.index,
+		pc:         c.pc,
+		delayTimer: c.delayTimer,
+		soundTimer: c.soundTimer,
+		stack:      append([]uint16(nil), c.stack...),
+		sp:         c.sp,
+		hires:      c.hires,
+		halted:     c.halted,
+		fault:      c.fault,
+		jumpFrom:   c.jumpFrom,
+		pages:      make([][]uint8, len(c.dirty)),
+		planes:     c.planes,
+		pattern:    c.pattern,
+		patterned:  c.patterned,
+		pitch:      c.pitch,
+		keyWait:    c.keyWait,
+		waiting:    c.waiting,
+		vblank:     c.vblank,
+		rpl:        c.rpl,
+		zones:      c.zones,
+		background: c.background,
+	}
+	cp.mega = c.mega
+	cp.mega.shown = append([]uint8(nil), c.mega.shown...)
+	prev := c.lastCheckpoint
+	for i := range cp.pages {
+		if prev != nil && !c.dirty[i] {
+			cp.pages[i] = prev.pages[i]
+		} else {
+			cp.pages[i] = append([]uint8(nil), c.memory[i*pageSize:(i+1)*pageSize]...)
+		}
+		c.dirty[i] = false
+	}
+	if prev != nil && !c.gfxDirty {
+		cp.gfx = prev.gfx
+	} else {
+		cp.gfx = append([]uint8(nil), c.gfx...)
+	}
+	c.gfxDirty = false
+	c.lastCheckpoint = cp
+	return cp
+}
+
+// Restore rewinds the machine to cp. The next checkpoint shares pages with cp.
+func (c *Chip8) Restore(cp *Checkpoint) {
+	c.inst = cp.inst
+	c.v = cp.v
+	c.index = cp.index
+	c.pc = cp.pc
+	c.delayTimer = cp.delayTimer
+	c.soundTimer = cp.soundTimer
+	c.stack = append(c.stack[:0], cp.stack...)
+	c.sp = cp.sp
+	c.hires = cp.hires
+	c.planes = cp.planes
+	c.pattern = cp.pattern
+	c.patterned = cp.patterned
+	c.pitch = cp.pitch
+	c.keyWait = cp.keyWait
+	c.waiting = cp.waiting
+	c.vblank = cp.vblank
+	c.rpl = cp.rpl
+	c.zones = cp.zones
+	c.background = cp.background
+	shown := c.mega.shown
+	c.mega = cp.mega
+	c.mega.shown = append(shown[:0], cp.mega.shown...)
+	c.halted = cp.halted
+	c.fault = cp.fault
+	c.jumpFrom = cp.jumpFrom
+	for i, page := range cp.pages {
+		copy(c.memory[i*pageSize:], page)
+		c.dirty[i] = false
+	}
+	copy(c.gfx, cp.gfx)
+	c.gfxDirty = false
+	c.lastCheckpoint = cp
+}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"math/rand"
+	"os"
+	"text/template"
+	"time"
+)
+
+// defaultProgStart is where CHIP-8 programs load and start running.
+// ETI-660 programs start at 0x600 instead; see -start-addr.
+const defaultProgStart = 0x200
+const memSize = 4096
+const xoMemSize = 0x10000 // XO-CHIP's 64KB
+const FONTSET_SIZE = 80
+const FONT_OFFSET = 0x50
+
+var fontSet = [FONTSET_SIZE]uint8{
+	0xF0, 0x90, 0x90, 0x90, 0xF0, // 0
+	0x20, 0x60, 0x20, 0x20, 0x70, // 1
+	0xF0, 0x10, 0xF0, 0x80, 0xF0, // 2
+	0xF0, 0x10, 0xF0, 0x10, 0xF0, // 3
+	0x90, 0x90, 0xF0, 0x10, 0x10, // 4
+	0xF0, 0x80, 0xF0, 0x10, 0xF0, // 5
+	0xF0, 0x80, 0xF0, 0x90, 0xF0, // 6
+	0xF0, 0x10, 0x20, 0x40, 0x40, // 7
+	0xF0, 0x90, 0xF0, 0x90, 0xF0, // 8
+	0xF0, 0x90, 0xF0, 0x10, 0xF0, // 9
+	0xF0, 0x90, 0xF0, 0x90, 0x90, // A
+	0xE0, 0x90, 0xE0, 0x90, 0xE0, // B
+	0xF0, 0x80, 0x80, 0x80, 0xF0, // C
+	0xE0, 0x90, 0x90, 0x90, 0xE0, // D
+	0xF0, 0x80, 0xF0, 0x80, 0xF0, // E
+	0xF0, 0x80, 0xF0, 0x80, 0x80, // F
+}
+
+// BIG_FONT_OFFSET is where the SCHIP 8x10 digits FX30 points at live.
+const BIG_FONT_OFFSET = 0xA0
+
+var bigFontSet = [16 * 10]uint8{
+	0xFF, 0xFF, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, // 0
+	0x18, 0x78, 0x78, 0x18, 0x18, 0x18, 0x18, 0x18, 0xFF, 0xFF, // 1
+	0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, // 2
+	0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, // 3
+	0xC3, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0x03, 0x03, 0x03, 0x03, // 4
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, // 5
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, // 6
+	0xFF, 0xFF, 0x03, 0x03, 0x06, 0x0C, 0x18, 0x18, 0x18, 0x18, // 7
+	0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, // 8
+	0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, // 9
+	0x7E, 0xFF, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xC3, // A
+	0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC, // B
+	0x3C, 0xFF, 0xC3, 0xC0, 0xC0, 0xC0, 0xC0, 0xC3, 0xFF, 0x3C, // C
+	0xFC, 0xFE, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFE, 0xFC, // D
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, // E
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xC0, 0xC0, // F
+}
+
+// randSource is where CXNN gets its random numbers. *rand.Rand satisfies it;
+// tests swap in a fixed sequence.
+type randSource interface {
+	Uint32() uint32
+}
+
+// Chip8 is our emulated processor state
+type Chip8 struct {
+	inst       uint16
+	memory     []uint8
+	v          [16]uint8 // register block
+	index      uint16    // index reg
+	pc         uint16    // program counter
+	gfx        []uint8   // DisplaySize pixels, one byte each, in a buffer big enough for hi-res
+	delayTimer uint8
+	soundTimer uint8
+	stack      []uint16 // return addresses; its length is the configured depth
+	sp         uint16
+	hires      bool      // SCHIP 128x64 mode, entered with 00FF
+	rpl        [16]uint8 // SCHIP RPL user flags; they survive Init, as on the HP-48
+	planes     uint8     // XO-CHIP display planes drawn to, a bit each; 1 outside XO-CHIP, and all of them in MegaChip mode
+	pattern    [16]uint8 // XO-CHIP audio pattern, 128 one-bit samples
+	patterned  bool      // F002 has loaded a pattern, which replaces the buzzer
+	pitch      uint8     // XO-CHIP pattern playback pitch; 64 is 4000 samples a second
+	zones      zoneGrid  // CHIP-8X foreground colors
+	background uint8     // CHIP-8X background color, an index into chip8xBackgrounds
+
+	mega megaState // MegaChip's display, palette and 24-bit I
+
+	keys    [16]bool       // keypad state, set by the frontend
+	keys2   [16]bool       // CHIP-8X second keypad
+	input   scheduledInput // key changes due at the next frame
+	keyWait keyWait        // progress of an FX0A waiting for a key
+
+	source  Input    // asked about the keypad each frame, if set
+	polled  [16]bool // what source said at the start of the frame
+	source2 Input    // the same for the second keypad
+	polled2 [16]bool
+
+	halted     bool         // set once the program jumps to itself
+	fault      error        // set when a protection check stops the program
+	jumpFrom   uint16       // address of the last instruction that set the PC
+	drawn      bool         // the display was touched during the current frame
+	vblank     bool         // no sprite drawn yet this frame, for the display wait quirk
+	waiting    bool         // an instruction is waiting for the next frame: FX0A, or DXYN under the display wait quirk
+	trace      io.Writer    // per-instruction state dump, os.Stdout by default
+	traceEvery int          // trace one in every traceEvery instructions, plus control flow
+	binTrace   *binaryTrace // compressed copy of the trace, if -trace-out is given
+	executed   uint64       // instructions executed since Init
+
+	unsupported map[uint16]*unsupportedUse // opcodes skipped or ignored since Init
+	opUse       map[*opcode]uint64         // executions of each table entry, if hapax8 batch is counting them
+
+	written        []bool      // memory bytes loaded or written since Init, for -warn-uninit
+	dirty          []bool      // memory pages written since lastCheckpoint
+	gfxDirty       bool        // display written since lastCheckpoint
+	lastCheckpoint *Checkpoint // pages are shared with this one
+
+	protectExec bool        // fault if the PC enters the interpreter area
+	progStart   uint16      // where programs load and run from; defaultProgStart unless -start-addr says otherwise
+	protectLow  protectMode // what to do about writes below progStart
+	boundsFault bool        // fault on accesses past the end of memory rather than wrapping
+	warnUninit  bool        // report reads of memory nothing has loaded or written
+	variant     variantMode // which dialect's opcodes exist
+	unknownOps  unknownMode // what to do about instructions no opcode matches
+	memImage    bool        // load files at 0 as full memory images
+	ipf         int         // instructions run per 60Hz frame
+	stackDepth  int         // return addresses the stack holds
+	rand        randSource  // random numbers for CXNN, seeded from the clock by default
+	prof        *profiler   // per-stage frame timings, if -profile is given
+	audio       *audioRing  // buzzer samples for ReadAudio, nil until it's first called
+
+	exports []*frameExporter // where the display is sent each frame: -export and -serial
+	shot    *stopShot        // saves the display and state when the program stops, if -shot-on is given
+
+	stateFormat *template.Template // how traced states are written, if -state-format is given; ToString otherwise
+
+	quirks Quirks // where this chip sides when interpreters disagree
+}
+
+// defaultIPF is the instructions-per-frame budget, about 600 per second.
+const defaultIPF = 10
+
+// defaultStackDepth is the 16 levels of nesting most interpreters offer.
+const defaultStackDepth = 16
+
+// maxStackDepth keeps sp within the one byte the binary trace stores it in.
+const maxStackDepth = 255
+
+// protectMode selects how writes to the interpreter area are treated. Some
+// ROMs overwrite low memory on purpose, so it's off by default.
+type protectMode int
+
+const (
+	protectOff   protectMode = iota
+	protectLog               // allow the write but report it on stderr
+	protectFault             // refuse the write and stop the program
+)
+
+// parseProtectMode parses the -protect-low flag value.
+func parseProtectMode(s string) (protectMode, error) {
+	switch s {
+	case "off":
+		return protectOff, nil
+	case "log":
+		return protectLog, nil
+	case "fault":
+		return protectFault, nil
+	}
+	return protectOff, fmt.Errorf("bad protection mode %q (want off, log or fault)", s)
+}
+
+// unknownMode selects what happens when the PC reaches an instruction that
+// isn't in the opcode table, usually because the ROM ran into its data or
+// was written for a later variant.
+type unknownMode int
+
+const (
+	unknownSkip unknownMode = iota // record it for the exit summary and step past it
+	unknownHalt                    // stop the program with an *ErrUnknownOpcode fault
+)
+
+// parseUnknownMode parses the -unknown-ops flag value.
+func parseUnknownMode(s string) (unknownMode, error) {
+	switch s {
+	case "skip":
+		return unknownSkip, nil
+	case "halt":
+		return unknownHalt, nil
+	}
+	return unknownSkip, fmt.Errorf("bad unknown opcode mode %q (want skip or halt)", s)
+}
+
+/*
+0x000-0x1FF - Chip 8 interpreter (contains font set in emu)
+0x050-0x0A0 - Used for the built in 4x5 pixel font set (0-F)
+0x200-0xFFF - Program ROM and work RAM
+*/
+
+// LoadProgram loads the program from a file into the Chip8's memory. A file
+// the size of memory, or any file when memImage is set, is a full
+// memory dump and is loaded at address 0, interpreter area included.
+func (c *Chip8) LoadProgram(prog string) {
+	data, err := os.ReadFile(prog)
+	if err != nil {
+		panic(err)
+	}
+	c.LoadROM(data)
+}
+
+// LoadROM loads a program image already in memory, as LoadProgram does.
+func (c *Chip8) LoadROM(data []byte) {
+	c.lastCheckpoint = nil // every page changes
+	if c.memImage || len(data) == len(c.memory) {
+		n := copy(c.memory, data)
+		c.markWritten(0, n)
+		return
+	}
+	n := copy(c.memory[c.progStart:], data)
+	c.markWritten(int(c.progStart), n)
+}
+
+// Init initializes the chip8 instance.
+func (c *Chip8) Init() {
+	c.inst = 0
+	c.index = 0
+	if c.progStart == 0 {
+		c.progStart = defaultProgStart
+	}
+	c.pc = c.progStart
+	c.sp = 0
+	c.v = [16]uint8{}
+	c.delayTimer = 0
+	c.soundTimer = 0
+	c.hires = false
+	c.planes = 1
+	c.pattern = [16]uint8{}
+	c.patterned = false
+	c.pitch = 64
+	for y := range c.zones {
+		for x := range c.zones[y] {
+			c.zones[y][x] = chip8xDefaultColor
+		}
+	}
+	c.background = 0
+	c.mega.reset()
+	c.halted = false
+	c.fault = nil
+	c.jumpFrom = 0
+	c.drawn = false
+	c.vblank, c.waiting = true, false
+	c.keyWait = keyWait{}
+	c.executed = 0
+	c.unsupported = nil
+	c.memory = make([]uint8, c.variant.memSize())
+	c.written = make([]bool, len(c.memory))
+	c.dirty = make([]bool, len(c.memory)/pageSize)
+	c.gfxDirty = false
+	c.lastCheckpoint = nil
+	if c.trace == nil {
+		c.trace = os.Stdout
+	}
+	if c.ipf <= 0 {
+		c.ipf = defaultIPF
+	}
+	if c.stackDepth <= 0 {
+		c.stackDepth = defaultStackDepth
+	}
+	c.stack = make([]uint16, c.stackDepth)
+	if c.rand == nil {
+		c.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	c.gfx = make([]uint8, hiresWidth*hiresHeight)
+	if c.variant == variantMegaChip {
+		c.gfx = make([]uint8, megaWidth*megaHeight)
+		c.mega.shown = make([]uint8, megaWidth*megaHeight)
+	}
+	for i, d := range fontSet {
+		c.memory[FONT_OFFSET+i] = d
+	}
+	for i, d := range bigFontSet {
+		c.memory[BIG_FONT_OFFSET+i] = d
+	}
+	c.markWritten(FONT_OFFSET, FONTSET_SIZE)
+	c.markWritten(BIG_FONT_OFFSET, len(bigFontSet))
+}
+
+// NewChip creates a new Chip8 instance loaded with the binary passed in
+func NewChip(bin string) *Chip8 {
+	c := new(Chip8)
+	c.Init()
+	c.LoadProgram(bin)
+	return c
+}
+
+// SetKey records keypad key k (0-F) going down or up.
+func (c *Chip8) SetKey(k uint8, down bool) {
+	c.keys[k&0xF] = down
+}
+
+// SetKey2 records key k going down or up on the second keypad, which only
+// CHIP-8X programs read.
+func (c *Chip8) SetKey2(k uint8, down bool) {
+	c.keys2[k&0xF] = down
+}
+
+// Halted reports whether the program has stopped on a jump-to-self.
+func (c *Chip8) Halted() bool {
+	return c.halted
+}
+
+// Pixel reports whether the display pixel at column x, row y is lit. gfx
+// holds one byte per pixel, row by row, 1 for lit.
+func (c *Chip8) Pixel(x, y int) bool {
+	w, _ := c.DisplaySize()
+	return c.visible()[y*w+x] != 0
+}
+
+// DisplaySize returns the display's current size in pixels: 64x32,
+// 128x64 once an SCHIP program switches to hi-res, 256x192 in MegaChip
+// mode, or 64x64 for hi-res CHIP-8.
+func (c *Chip8) DisplaySize() (w, h int) {
+	if c.mega.on {
+		return megaWidth, megaHeight
+	}
+	if c.variant == variantHires {
+		return displayWidth, 2 * displayHeight
+	}
+	if c.hires {
+		return hiresWidth, hiresHeight
+	}
+	return displayWidth, displayHeight
+}
+
+// screen is the part of gfx the current display size uses.
+func (c *Chip8) screen() []uint8 {
+	w, h := c.DisplaySize()
+	return c.gfx[:w*h]
+}
+
+// displayWidth and displayHeight are the CHIP-8 display size in pixels;
+// hiresWidth and hiresHeight are SCHIP's hi-res mode.
+const (
+	displayWidth  = 64
+	displayHeight = 32
+	hiresWidth    = 128
+	hiresHeight   = 64
+)
+
+// Hi-res CHIP-8 ROMs begin with hiresEntry, a jump into the two-page display
+// setup they carry from 0x202 to 0x2BF, and their own code starts at
+// hiresStart.
+const (
+	hiresEntry = 0x1260
+	hiresStart = 0x2C0
+)
+
+// framePalette colors Framebuffer images: index 0 is off, 1 is on. XO-CHIP
+// programs also light 2, the second plane, and 3, both; the colors are
+// Octo's.
+var framePalette = color.Palette{
+	color.Black,
+	color.White,
+	color.RGBA{0xFF, 0x66, 0x00, 0xFF},
+	color.RGBA{0x66, 0x22, 0x00, 0xFF},
+}
+
+// chip8xColors are the VIP color board's eight colors, numbered as BXYN
+// takes them: bit 0 is red, bit 1 blue and bit 2 green.
+var chip8xColors = color.Palette{
+	color.Black,
+	color.RGBA{0xFF, 0x00, 0x00, 0xFF}, // red
+	color.RGBA{0x00, 0x00, 0xFF, 0xFF}, // blue
+	color.RGBA{0xFF, 0x00, 0xFF, 0xFF}, // violet
+	color.RGBA{0x00, 0xFF, 0x00, 0xFF}, // green
+	color.RGBA{0xFF, 0xFF, 0x00, 0xFF}, // yellow
+	color.RGBA{0x00, 0xFF, 0xFF, 0xFF}, // aqua
+	color.White,
+}
+
+// chip8xBackgrounds are the background colors 02A0 steps through, starting
+// from blue.
+var chip8xBackgrounds = [...]uint8{2, 0, 4, 1}
+
+// zoneGrid holds the CHIP-8X color of each 8x1 pixel strip of the display.
+type zoneGrid [displayHeight][displayWidth / 8]uint8
+
+// chip8xDefaultColor is what zones are lit in until BXYN colors them.
+const chip8xDefaultColor = 7
+
+// pixelColor returns the color number, in chip8xColors, that pixel (x, y)
+// shows under CHIP-8X.
+func (c *Chip8) pixelColor(x, y int) uint8 {
+	if c.Pixel(x, y) {
+		return c.zones[y][x/8]
+	}
+	return chip8xBackgrounds[c.background]
+}
+
+// Colors returns the colors pixel (x, y) is drawn in lit and unlit: white
+// on black, under CHIP-8X its zone's color on the background, or in
+// MegaChip mode its palette color on black.
+func (c *Chip8) Colors(x, y int) (on, off color.Color) {
+	if c.mega.on {
+		return c.mega.color(c.mega.shown[y*megaWidth+x]), color.Black
+	}
+	if c.variant != variantCHIP8X {
+		return color.White, color.Black
+	}
+	return chip8xColors[c.zones[y][x/8]], chip8xColors[chip8xBackgrounds[c.background]]
+}
+
+// Framebuffer returns a copy of the display as a paletted image, index 0
+// for unlit pixels and 1 for lit ones, or a plane mask for XO-CHIP, so
+// embedders can draw it however they like. Swap the image's Palette to
+// recolor it. CHIP-8X displays use chip8xColors, each pixel its color's
+// number, and MegaChip mode the program's palette.
+func (c *Chip8) Framebuffer() image.Image {
+	if c.mega.on {
+		return c.megaFramebuffer()
+	}
+	w, h := c.DisplaySize()
+	if c.variant == variantCHIP8X {
+		img := image.NewPaletted(image.Rect(0, 0, w, h), chip8xColors)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				img.Pix[y*img.Stride+x] = c.pixelColor(x, y)
+			}
+		}
+		return img
+	}
+	img := image.NewPaletted(image.Rect(0, 0, w, h), framePalette)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Pix[y*img.Stride+x] = c.gfx[y*w+x]
+		}
+	}
+	return img
+}
+
+// Fault returns the error that stopped the program, if a protection check did.
+func (c *Chip8) Fault() error {
+	return c.fault
+}
+
+// readMem loads the byte at addr for an instruction. Past the end of
+// memory it wraps around to the start, or faults under -strict.
+func (c *Chip8) readMem(addr uint16) uint8 {
+	return c.readAt(int(addr))
+}
+
+// readAt is readMem for addresses wider than 16 bits, which MegaChip's I
+// gives.
+func (c *Chip8) readAt(addr int) uint8 {
+	i := c.memAddr(addr, "read")
+	if c.warnUninit && !c.written[i] {
+		// Reading a buffer the ROM forgot to fill is the usual cause; one
+		// warning per byte is enough to find it.
+		fmt.Fprintf(diag, "pc %#x read %#x, which nothing has loaded or written\n", c.pc, i)
+		c.written[i] = true
+	}
+	return c.memory[i]
+}
+
+// markWritten records n bytes from addr as initialized.
+func (c *Chip8) markWritten(addr, n int) {
+	for i := addr; i < addr+n; i++ {
+		c.written[i] = true
+	}
+}
+
+// memAddr brings an address an instruction uses within memory, wrapping it
+// or, under -strict, faulting.
+func (c *Chip8) memAddr(addr int, what string) int {
+	if addr < len(c.memory) {
+		return addr
+	}
+	if c.boundsFault && c.fault == nil {
+		c.fault = fmt.Errorf("pc %#x %s past the end of memory at %#x", c.pc, what, addr)
+	}
+	return addr % len(c.memory)
+}
+
+// writeMem stores val at addr, applying the low-memory write protection.
+func (c *Chip8) writeMem(addr uint16, val uint8) {
+	addr = uint16(c.memAddr(int(addr), "wrote"))
+	if c.fault != nil {
+		return
+	}
+	if addr < c.progStart {
+		switch c.protectLow {
+		case protectLog:
+			fmt.Fprintf(diag, "pc %#x wrote %#x to protected address %#x\n", c.pc, val, addr)
+		case protectFault:
+			c.fault = fmt.Errorf("pc %#x wrote to protected address %#x", c.pc, addr)
+			return
+		}
+	}
+	c.memory[addr] = val
+	c.written[addr] = true
+	c.markDirty(addr)
+}
+
+// Decode decodes a single instruction.
+func (c *Chip8) Decode() {
+	topByte := bits.RotateLeft16(uint16(c.memory[c.pc]), 8) // shift the top byte up 8
+	bottomByte := uint16(c.memory[c.pc+1])
+	c.inst = topByte | bottomByte
+}
+
+// ToString prints out the chip's state: index, pc, sp, and reg block
+func (c *Chip8) ToString() string {
+	return fmt.Sprintf("Chip State:\n\tinst: %#x\n\tindex: %#x\n\tpc: %#x\n\tsp: %d\n\tregs: %+v\n", c.inst, c.index, c.pc, c.sp, c.v)
+}
+
+func topNibble(i uint16) uint16 {
+	return (i & 0xF000) >> 12
+}
+func bottomNibble(i uint16) uint16 {
+	return (i & 0x000F)
+}
+
+func bottomByte(i uint16) uint16 {
+	return i & 0x00FF
+}
+
+func targetAddr(i uint16) uint16 {
+	return (i & 0x0FFF)
+}
+
+// SetIndex sets the index register if current inst is ANNN
+func (c *Chip8) SetIndex() {
+	c.index = c.inst & 0x0FFF
+	c.mega.indexHi = 0
+}
+
+// SetPC sets the PC register to the given address
+func (c *Chip8) SetPC(newaddr uint16) {
+	c.jumpFrom = c.pc
+	c.pc = newaddr
+}
+
+// IncPC increments the PC (adds 2 since the word is a short)
+func (c *Chip8) IncPC() {
+	c.pc += 2
+}
+
+// GetImm pulls out the immediate value from the current instruction.
+// numDigs is the number of hex digits to extract from the instruction.
+func (c *Chip8) GetImm(numDigs int) uint8 {
+	switch numDigs {
+	case 1:
+		return uint8(c.inst & 0x000F)
+	case 2:
+		return uint8(c.inst & 0x00FF)
+	case 3:
+		return uint8(c.inst & 0x0FFF)
+	default:
+		panic("bad arg")
+	}
+}
+
+func (c *Chip8) GetXReg() uint16 {
+	return c.inst & 0x0F00 >> 8
+}
+
+func (c *Chip8) GetYReg() uint16 {
+	return c.inst & 0x00F0 >> 4
+}
+
+// Math8 executes the correct math instruction based on the bottom nibble of an inst starting with 0x8.
+func (c *Chip8) Math8() {
+	x := c.GetXReg()
+	y := c.GetYReg()
+	xVal := c.v[x]
+	yVal := c.v[y]
+	switch bottomNibble(c.inst) {
+	case 0x0:
+		c.v[x] = yVal
+	case 0x1:
+		c.v[x] = xVal | yVal
+		c.logicVF()
+	case 0x2:
+		c.v[x] = xVal & yVal
+		c.logicVF()
+	case 0x3:
+		c.v[x] = xVal ^ yVal
+		c.logicVF()
+	case 0x4:
+		add := uint16(xVal) + uint16(yVal)
+		c.v[x] = uint8(add)
+		c.v[0xF] = uint8(add >> 8)
+	case 0x5:
+		c.v[x] = xVal - yVal
+		c.v[0xF] = notBorrow(xVal, yVal)
+	case 0x6:
+		src := c.shiftSource(xVal, yVal)
+		c.v[x] = src >> 1
+		c.v[0xF] = src & 1
+	case 0x7:
+		c.v[x] = yVal - xVal
+		c.v[0xF] = notBorrow(yVal, xVal)
+	case 0xE:
+		src := c.shiftSource(xVal, yVal)
+		c.v[x] = src << 1
+		c.v[0xF] = src >> 7
+	}
+}
+
+// shiftSource is the value 8XY6 and 8XYE shift: VY on the COSMAC VIP, VX
+// itself with the shift quirk.
+func (c *Chip8) shiftSource(xVal, yVal uint8) uint8 {
+	if c.quirks.Shift {
+		return xVal
+	}
+	return yVal
+}
+
+// logicVF applies the logic op quirk: the VIP's 8XY1-8XY3 ran through code
+// that left VF at 0.
+func (c *Chip8) logicVF() {
+	if c.quirks.LogicResetVF {
+		c.v[0xF] = 0
+	}
+}
+
+// notBorrow is the VF result of a - b: 1 unless the subtraction borrows.
+// The flag is written after the result, so it wins when X is F.
+func notBorrow(a, b uint8) uint8 {
+	if a >= b {
+		return 1
+	}
+	return 0
+}
+
+// Execute executes a single instruction.
+func (c *Chip8) Execute() {
+	if c.halted || c.fault != nil {
+		return
+	}
+	if c.protectExec && c.pc < c.progStart {
+		c.fault = fmt.Errorf("pc %#x is in the interpreter area (jumped from %#x)", c.pc, c.jumpFrom)
+		return
+	}
+	if int(c.pc)+1 >= len(c.memory) {
+		c.fault = fmt.Errorf("pc %#x ran off the end of memory (jumped from %#x)", c.pc, c.jumpFrom)
+		return
+	}
+	t := c.prof.start()
+	c.Decode()
+	t = c.prof.lap(stageDecode, t)
+	if c.traceSampled() {
+		// Formatting the state allocates; don't do it just to throw it away.
+		if c.trace != io.Discard {
+			c.writeState(c.trace)
+		}
+		if c.binTrace != nil {
+			c.binTrace.record(c)
+		}
+	}
+	c.executed++
+	// 0000 matches 0NNN, but it's zeroed memory rather than a machine code
+	// call, so it's stepped over or stops the program as unknown ones are.
+	if op := lookupOpcode(c.inst); op != nil && c.inst != 0x0 {
+		if c.opUse != nil {
+			c.opUse[op]++
+		}
+		op.exec(c)
+	} else {
+		c.unknownOpcode()
+	}
+	c.prof.lap(stageExecute, t)
+}
+
+// TickTimers counts the delay and sound timers down by one 60Hz tick.
+func (c *Chip8) TickTimers() {
+	if c.delayTimer > 0 {
+		c.delayTimer--
+	}
+
+	if c.soundTimer > 0 {
+		c.soundTimer--
+	}
+}
+
+// FrameResult reports what happened during one RunFrame.
+type FrameResult struct {
+	Drawn  bool // the program cleared or drew to the display
+	Sound  bool // the sound timer is running
+	Halted bool // the program has stopped on a jump-to-self
+}
+
+// RunFrame runs one 60Hz frame: up to ipf instructions followed by a single
+// timer tick. The error is the fault that stopped the program, if any.
+func (c *Chip8) RunFrame() (FrameResult, error) {
+	c.drawn = false
+	c.vblank, c.waiting = true, false
+	c.applyInput()
+	for i := 0; i < c.ipf && !c.halted && c.fault == nil && !c.waiting; i++ {
+		c.Execute()
+	}
+	c.frameAudio()
+	c.TickTimers()
+	for _, e := range c.exports {
+		e.send(c)
+	}
+	if c.shot != nil {
+		c.shot.check(c)
+	}
+	return FrameResult{Drawn: c.drawn, Sound: c.soundTimer > 0, Halted: c.halted}, c.fault
+}
@@ -1,6 +1,22 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
 
 const TESTDIR = "./test_asm/bin/"
 
@@ -12,17 +28,2256 @@ func TestStor(t *testing.T) {
 	for i := 0; i < 3; i++ {
 		chip.Execute()
 	}
-	if chip.memory[0xA] != 0xAB {
-		t.Errorf("Got %#x, expected 0xAB", chip.memory[0xA])
+	if chip.memory[0xA] != 0 || chip.memory[0xB] != 0xAB {
+		t.Errorf("Got % x, expected V0 and V1, 00 ab", chip.memory[0xA:0xC])
 	}
 }
 
 func TestRead(t *testing.T) {
 	chip := NewChip(TESTDIR + "test_read.bin")
+	for i := 0; i < 5; i++ {
+		chip.Execute()
+	}
+	if chip.v[1] != 0xAB || chip.v[2] != 0 {
+		t.Errorf("Got v1 %#x, v2 %#x, expected 0xAB and 0", chip.v[1], chip.v[2])
+	}
+}
+
+// TestLoadStoreQuirk tests where each load/store quirk mode leaves I
+func TestLoadStoreQuirk(t *testing.T) {
+	tests := []struct {
+		mode  loadStoreMode
+		wantI uint16
+	}{
+		{loadStoreVIP, 0x303},
+		{loadStoreCHIP48, 0x302},
+		{loadStoreSCHIP, 0x300},
+	}
+	for _, tt := range tests {
+		chip := new(Chip8)
+		chip.Init()
+		chip.LoadROM([]uint8{0xF2, 0x55, 0xA3, 0x00, 0xF2, 0x65}) // STOR v2, LOADI 0x300, READ v2
+		chip.quirks.LoadStore = tt.mode
+		chip.index = 0x300
+		chip.v = [16]uint8{1, 2, 3}
+		chip.Execute()
+		if chip.index != tt.wantI || !bytes.Equal(chip.memory[0x300:0x304], []uint8{1, 2, 3, 0}) {
+			t.Errorf("Mode %d: STOR left I at %#x and memory % x", tt.mode, chip.index, chip.memory[0x300:0x304])
+		}
+		chip.v = [16]uint8{}
+		chip.Execute()
+		chip.Execute()
+		if chip.index != tt.wantI || chip.v != [16]uint8{1, 2, 3} {
+			t.Errorf("Mode %d: READ left I at %#x and registers %v", tt.mode, chip.index, chip.v)
+		}
+	}
+}
+
+// screenRows renders the top-left w x h pixels of the display, # for lit.
+func screenRows(c *Chip8, w, h int) []string {
+	var rows []string
+	for y := 0; y < h; y++ {
+		var row strings.Builder
+		for x := 0; x < w; x++ {
+			if c.Pixel(x, y) {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	return rows
+}
+
+// TestDraw tests XOR drawing of the 0 glyph and the collision flag
+func TestDraw(t *testing.T) {
+	chip := NewChip(TESTDIR + "test_draw.bin")
+	chip.trace = io.Discard
+	for i := 0; i < 4; i++ {
+		chip.Execute()
+	}
+	want := []string{"......", ".####.", ".#..#.", ".#..#.", ".#..#.", ".####.", "......"}
+	if got := screenRows(chip, 6, 7); !slices.Equal(got, want) {
+		t.Errorf("Got\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if chip.v[0xF] != 0 {
+		t.Errorf("Got VF %d drawing on a clear display, expected 0", chip.v[0xF])
+	}
+	chip.SetPC(0x206) // draw it again
+	chip.Execute()
+	if chip.v[0xF] != 1 || slices.Contains(screenRows(chip, 6, 7), ".####.") {
+		t.Errorf("Got VF %d, expected a collision that erases the glyph", chip.v[0xF])
+	}
+}
+
+// TestDrawClip tests that the start position wraps and the sprite clips
+func TestDrawClip(t *testing.T) {
+	chip := new(Chip8)
+	chip.Init()
+	chip.LoadROM([]uint8{
+		0xA2, 0x0A, // 200 LOADI 0x20A
+		0x61, 0x7E, // 202 LOAD v1 0x7E, x 126 wraps to 62
+		0x62, 0x3F, // 204 LOAD v2 0x3F, y 63 wraps to 31
+		0xD1, 0x22, // 206 DRAW v1 v2 0x2
+		0x00, 0x00,
+		0xFF, 0xFF, // 20A sprite
+	})
+	for i := 0; i < 4; i++ {
+		chip.Execute()
+	}
+	lit := 0
+	for _, p := range chip.gfx {
+		lit += int(p)
+	}
+	if lit != 2 || !chip.Pixel(62, 31) || !chip.Pixel(63, 31) {
+		t.Errorf("Got %d pixels lit, expected only (62,31) and (63,31)", lit)
+	}
+}
+
+// TestHalt tests that a jump to itself halts the chip
+func TestHalt(t *testing.T) {
+	chip := NewChip(TESTDIR + "test_halt.bin")
+	for i := 0; i < 2; i++ {
+		chip.Execute()
+	}
+	if !chip.Halted() {
+		t.Errorf("Chip not halted on self-jump")
+	}
+	chip.Execute()
+	if chip.pc != 0x202 {
+		t.Errorf("Got pc %#x, expected 0x202", chip.pc)
+	}
+}
+
+// TestCall tests that RET comes back to the instruction after the CALL
+func TestCall(t *testing.T) {
+	chip := NewChip(TESTDIR + "test_call.bin")
+	for i := 0; i < 5; i++ {
+		chip.Execute()
+	}
+	if chip.v[1] != 1 || chip.v[2] != 2 {
+		t.Errorf("Got v1 %#x, v2 %#x, expected 0x1 and 0x2", chip.v[1], chip.v[2])
+	}
+	if chip.sp != 0 || !chip.Halted() {
+		t.Errorf("Got sp %d, halted %v, expected 0 and halted", chip.sp, chip.Halted())
+	}
+}
+
+// TestStackFaults tests that overflowing the configured depth and returning
+// with an empty stack both fault
+func TestStackFaults(t *testing.T) {
+	chip := new(Chip8)
+	chip.stackDepth = 4
+	chip.Init()
+	chip.LoadROM([]uint8{0x22, 0x00}) // CALL 0x200
+	for i := 0; i < 5; i++ {
+		chip.Execute()
+	}
+	if chip.Fault() == nil || !strings.Contains(chip.Fault().Error(), "overflow") {
+		t.Errorf("Got fault %v after 5 nested calls, expected an overflow", chip.Fault())
+	}
+	chip.Init()
+	chip.LoadROM([]uint8{0x00, 0xEE}) // RET
+	chip.Execute()
+	if chip.Fault() == nil || !strings.Contains(chip.Fault().Error(), "underflow") {
+		t.Errorf("Got fault %v, expected an underflow", chip.Fault())
+	}
+}
+
+// fixedRand returns the same number every time.
+type fixedRand uint32
+
+func (r fixedRand) Uint32() uint32 { return uint32(r) }
+
+// TestMathFlags tests the 8XY4, 8XY5 and 8XY7 results and VF, including
+// when VF is itself an operand
+func TestMathFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     uint16
+		vx, vy uint8
+		want   uint8 // VX afterwards
+		wantVF uint8
+	}{
+		{"add", 0x8124, 0x10, 0x20, 0x30, 0},
+		{"add carry", 0x8124, 0xFF, 0x01, 0x00, 1},
+		{"add max", 0x8124, 0xFF, 0xFF, 0xFE, 1},
+		{"sub", 0x8125, 0x30, 0x10, 0x20, 1},
+		{"sub equal", 0x8125, 0x10, 0x10, 0x00, 1},
+		{"sub borrow", 0x8125, 0x10, 0x30, 0xE0, 0},
+		{"subn", 0x8127, 0x10, 0x30, 0x20, 1},
+		{"subn borrow", 0x8127, 0x30, 0x10, 0xE0, 0},
+		// With X = F the flag overwrites the result.
+		{"add into VF", 0x8F24, 0xFF, 0x02, 1, 1},
+		{"sub into VF", 0x8F25, 0x05, 0x09, 0, 0},
+		{"subn into VF", 0x8F27, 0x05, 0x09, 1, 1},
+		// With Y = F the old VF is the operand.
+		{"add from VF", 0x81F4, 0x80, 0x80, 0x00, 1},
+	}
+	for _, tt := range tests {
+		chip := new(Chip8)
+		chip.Init()
+		chip.LoadROM([]uint8{uint8(tt.op >> 8), uint8(tt.op)})
+		x, y := tt.op>>8&0xF, tt.op>>4&0xF
+		chip.v[x] = tt.vx
+		chip.v[y] = tt.vy
+		chip.Execute()
+		got := chip.v[x]
+		if got != tt.want || chip.v[0xF] != tt.wantVF {
+			t.Errorf("%s: got VX %#x, VF %d, expected %#x, %d", tt.name, got, chip.v[0xF], tt.want, tt.wantVF)
+		}
+	}
+}
+
+// TestJumpOffset tests BNNN and its CHIP-48 BXNN reading
+func TestJumpOffset(t *testing.T) {
+	for _, tt := range []struct {
+		quirk  bool
+		wantPC uint16
+	}{
+		{false, 0x314},
+		{true, 0x330},
+	} {
+		chip := new(Chip8)
+		chip.Init()
+		chip.LoadROM([]uint8{0xB3, 0x10}) // JUMPI 0x310
+		chip.quirks.JumpOffset = tt.quirk
+		chip.v[0] = 0x04
+		chip.v[3] = 0x20
+		chip.Execute()
+		if chip.pc != tt.wantPC {
+			t.Errorf("Quirk %v: got pc %#x, expected %#x", tt.quirk, chip.pc, tt.wantPC)
+		}
+	}
+}
+
+// TestShift tests 8XY6 and 8XYE in both quirk modes, with VF getting the
+// bit shifted out
+func TestShift(t *testing.T) {
+	tests := []struct {
+		op     uint16
+		quirk  bool
+		want   uint8 // VX afterwards
+		wantVF uint8
+	}{
+		{0x8126, false, 0x40, 1}, // VY = 0x81
+		{0x812E, false, 0x02, 1},
+		{0x8126, true, 0x21, 0}, // VX = 0x42
+		{0x812E, true, 0x84, 0},
+		{0x8F26, false, 1, 1}, // the flag overwrites the result
+	}
+	for _, tt := range tests {
+		chip := new(Chip8)
+		chip.Init()
+		chip.LoadROM([]uint8{uint8(tt.op >> 8), uint8(tt.op)})
+		chip.quirks.Shift = tt.quirk
+		x := tt.op >> 8 & 0xF
+		chip.v[x] = 0x42
+		chip.v[2] = 0x81
+		chip.Execute()
+		if chip.v[x] != tt.want || chip.v[0xF] != tt.wantVF {
+			t.Errorf("%04X quirk %v: got VX %#x, VF %d, expected %#x, %d", tt.op, tt.quirk, chip.v[x], chip.v[0xF], tt.want, tt.wantVF)
+		}
+	}
+}
+
+// TestRand tests that CXNN masks the random byte with NN
+func TestRand(t *testing.T) {
+	chip := new(Chip8)
+	chip.rand = fixedRand(0x12345678)
+	chip.Init()
+	chip.LoadROM([]uint8{0xC1, 0x0F, 0xC2, 0xFF}) // RAND v1 0x0F, RAND v2 0xFF
+	chip.Execute()
+	chip.Execute()
+	if chip.v[1] != 0x08 || chip.v[2] != 0x78 {
+		t.Errorf("Got v1 %#x, v2 %#x, expected 0x8 and 0x78", chip.v[1], chip.v[2])
+	}
+}
+
+// TestMinimize tests that the minimizer blanks everything but the faulting call
+func TestMinimize(t *testing.T) {
+	rom := []uint8{
+		0x61, 0x05, // 200 LOAD v1 0x5
+		0xA3, 0x00, // 202 LOADI 0x300
+		0x63, 0x07, // 204 LOAD v3 0x7
+		0x22, 0x06, // 206 CALL 0x206
+		0xF0, 0x90, // 208 data
+	}
+	got, fault, err := minimizeROM(rom, defaultSettings(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint8{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x22, 0x06} // 0000s are stepped over
+	if !bytes.Equal(got, want) || !strings.Contains(fault, "overflow") {
+		t.Errorf("Got % x faulting with %q, expected % x and an overflow", got, fault, want)
+	}
+	if _, steps := faultRun(got, defaultSettings(), 1000); steps != 20 {
+		t.Errorf("Got the fault after %d instructions, expected 20", steps)
+	}
+}
+
+// TestKeySkip tests EX9E and EXA1 against the keypad state
+func TestKeySkip(t *testing.T) {
+	rom := []uint8{
+		0x61, 0x0A, // 200 LOAD v1 0xA
+		0xE1, 0x9E, // 202 SKPR v1
+		0xE1, 0xA1, // 204 SKUP v1
+	}
+	chip := new(Chip8)
+	chip.Init()
+	chip.LoadROM(rom)
+	chip.Execute()
+	chip.Execute()
+	if chip.pc != 0x204 {
+		t.Errorf("Got pc %#x with key A up, expected SKPR not to skip", chip.pc)
+	}
+	chip.Execute()
+	if chip.pc != 0x208 {
+		t.Errorf("Got pc %#x with key A up, expected SKUP to skip", chip.pc)
+	}
+	chip.Init()
+	chip.LoadROM(rom)
+	chip.SetKey(0xA, true)
+	chip.Execute()
+	chip.Execute()
+	if chip.pc != 0x206 {
+		t.Errorf("Got pc %#x with key A down, expected SKPR to skip", chip.pc)
+	}
+}
+
+// TestWaitKey tests that FX0A ignores a key held from before, and finishes
+// on the release of the next key pressed or, with the quirk, on its press
+func TestWaitKey(t *testing.T) {
+	for _, tt := range []struct {
+		press bool
+		frame int // the frame FX0A finishes in
+	}{
+		{false, 4},
+		{true, 2},
+	} {
+		chip := new(Chip8)
+		chip.trace = io.Discard
+		chip.Init()
+		chip.quirks.KeyPress = tt.press
+		chip.LoadROM([]uint8{0xF3, 0x0A}) // 200 KEYD v3
+		chip.SetKey(5, true)
+		for frame := 1; frame <= 4; frame++ {
+			if frame == 2 {
+				chip.SetKey(5, false)
+				chip.PressKey(7, 2) // down in frames 2 and 3
+			}
+			chip.RunFrame()
+			if done := chip.pc != 0x200; done != (frame >= tt.frame) {
+				t.Errorf("press %v: pc %#x after frame %d, expected FX0A to finish in frame %d", tt.press, chip.pc, frame, tt.frame)
+			}
+		}
+		if chip.v[3] != 7 {
+			t.Errorf("press %v: got v3 %d, expected key 7", tt.press, chip.v[3])
+		}
+	}
+}
+
+// TestTimerOps tests setting both timers and reading the delay timer back
+func TestTimerOps(t *testing.T) {
+	chip := new(Chip8)
+	chip.Init()
+	chip.LoadROM([]uint8{
+		0x61, 0x05, // 200 LOAD v1 0x5
+		0xF1, 0x15, // 202 LOADD v1
+		0xF1, 0x18, // 204 LOADS v1
+		0xF2, 0x07, // 206 MOVED v2
+	})
+	for i := 0; i < 3; i++ {
+		chip.Execute()
+	}
+	chip.TickTimers()
+	chip.Execute()
+	if chip.delayTimer != 4 || chip.soundTimer != 4 || chip.v[2] != 4 {
+		t.Errorf("Got delay %d, sound %d, v2 %d, expected 4 each", chip.delayTimer, chip.soundTimer, chip.v[2])
+	}
+}
+
+// TestAddIndex tests FX1E with and without the VF overflow quirk
+func TestAddIndex(t *testing.T) {
+	tests := []struct {
+		index  uint16
+		quirk  bool
+		wantI  uint16
+		wantVF uint8
+	}{
+		{0x100, false, 0x110, 0xAA},
+		{0xFF8, false, 0x1008, 0xAA},
+		{0x100, true, 0x110, 0},
+		{0xFF8, true, 0x1008, 1},
+	}
+	for _, tt := range tests {
+		chip := new(Chip8)
+		chip.Init()
+		chip.LoadROM([]uint8{0xF1, 0x1E}) // ADDI v1
+		chip.quirks.IndexOverflow = tt.quirk
+		chip.index = tt.index
+		chip.v[1] = 0x10
+		chip.v[0xF] = 0xAA
+		chip.Execute()
+		if chip.index != tt.wantI || chip.v[0xF] != tt.wantVF {
+			t.Errorf("I %#x, quirk %v: got I %#x, VF %#x, expected %#x, %#x", tt.index, tt.quirk, chip.index, chip.v[0xF], tt.wantI, tt.wantVF)
+		}
+	}
+}
+
+// TestFontChar tests that FX29 points I at the glyph for the digit in VX
+func TestFontChar(t *testing.T) {
+	chip := new(Chip8)
+	chip.Init()
+	chip.LoadROM([]uint8{0x61, 0x0B, 0xF1, 0x29}) // LOAD v1 0xB, LDSPR v1
+	chip.Execute()
+	chip.Execute()
+	if chip.index != FONT_OFFSET+55 {
+		t.Errorf("Got I %#x, expected %#x", chip.index, FONT_OFFSET+55)
+	}
+	if !bytes.Equal(chip.memory[chip.index:chip.index+5], fontSet[55:60]) {
+		t.Errorf("Got glyph % x, expected the B glyph", chip.memory[chip.index:chip.index+5])
+	}
+}
+
+// TestBCD tests that FX33 writes hundreds, tens and ones from I onwards
+func TestBCD(t *testing.T) {
+	tests := []struct {
+		v    uint8
+		want []uint8
+	}{
+		{0, []uint8{0, 0, 0}},
+		{7, []uint8{0, 0, 7}},
+		{42, []uint8{0, 4, 2}},
+		{109, []uint8{1, 0, 9}},
+		{255, []uint8{2, 5, 5}},
+	}
+	for _, tt := range tests {
+		chip := new(Chip8)
+		chip.Init()
+		chip.LoadROM([]uint8{0xF1, 0x33}) // BCD v1
+		chip.index = 0x300
+		chip.v[1] = tt.v
+		chip.Execute()
+		if got := chip.memory[0x300:0x303]; !bytes.Equal(got, tt.want) {
+			t.Errorf("%d: got % x, expected % x", tt.v, got, tt.want)
+		}
+	}
+}
+
+// TestInput tests that FX0A and EX9E read the keypad from an Input as well
+// as from SetKey
+func TestInput(t *testing.T) {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.LoadROM([]byte{0xF0, 0x0A, 0xE1, 0x9E, 0x12, 0x02, 0x12, 0x06})
+	chip.SetInput(&scriptInput{steps: []uint16{0, 1 << 0x7, 0, 1 << 0x3}})
+	chip.v[1] = 0x3
+	for frame := 0; frame < 3; frame++ {
+		chip.RunFrame()
+		if done := chip.pc != 0x200; done != (frame == 2) {
+			t.Errorf("Frame %d: got PC %03X, expected FX0A to finish in frame 2", frame, chip.pc)
+		}
+	}
+	if chip.v[0] != 0x7 {
+		t.Errorf("Got V0 %X, expected 7", chip.v[0])
+	}
+	for frame := 3; frame < 5; frame++ {
+		chip.RunFrame() // the script's last step, key 3, stays
+		if chip.pc != 0x206 {
+			t.Errorf("Frame %d: got PC %03X with key 3 held, expected 206", frame, chip.pc)
+		}
+	}
+
+	chip.Init()
+	chip.LoadROM([]byte{0xE1, 0x9E, 0x12, 0x00, 0x12, 0x04})
+	chip.v[1] = 0x3
+	chip.SetInput(&scriptInput{steps: []uint16{0}})
+	chip.SetKey(0x3, true)
+	chip.RunFrame()
+	if chip.pc != 0x204 {
+		t.Errorf("Key 3 set with SetKey: got PC %03X, expected 204", chip.pc)
+	}
+
+	script := &scriptInput{steps: []uint16{1 << 0x2, 1<<0x2 | 1<<0x9, 0}}
+	if k := script.WaitKey(); k != 0x2 {
+		t.Errorf("Got WaitKey %X, expected the first step's 2", k)
+	}
+	if k := script.WaitKey(); k != 0x9 {
+		t.Errorf("Got WaitKey %X, expected 9, the key that went down next", k)
+	}
+	if k := script.WaitKey(); k != 0 || script.frame != 3 {
+		t.Errorf("Got WaitKey %X at frame %d, expected 0 at the script's end, frame 3", k, script.frame)
+	}
+}
+
+// TestScheduledInput tests that PressKey and SetKeys act on frame boundaries
+func TestScheduledInput(t *testing.T) {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.PressKey(5, 2)
+	if chip.keys[5] {
+		t.Errorf("Key 5 down before the next frame")
+	}
+	for frame, want := range []bool{true, true, false} {
+		chip.RunFrame()
+		if chip.keys[5] != want {
+			t.Errorf("Frame %d: got key 5 down %v, expected %v", frame, chip.keys[5], want)
+		}
+	}
+	chip.SetKeys(0x8001)
+	chip.RunFrame()
+	if !chip.keys[0] || !chip.keys[0xF] || chip.keys[5] {
+		t.Errorf("Got keys %v, expected only 0 and F down", chip.keys)
+	}
+}
+
+// TestProtectExec tests that a jump below 0x200 faults only when protection is on
+func TestProtectExec(t *testing.T) {
+	chip := NewChip(TESTDIR + "test_protect.bin")
+	chip.Execute()
+	chip.Execute()
+	if chip.Fault() != nil {
+		t.Errorf("Got fault %v without protection", chip.Fault())
+	}
+	chip = NewChip(TESTDIR + "test_protect.bin")
+	chip.protectExec = true
+	chip.Execute()
+	chip.Execute()
+	if chip.Fault() == nil {
+		t.Fatalf("No fault after jumping to %#x", chip.pc)
+	}
+	if !strings.Contains(chip.Fault().Error(), "jumped from 0x200") {
+		t.Errorf("Fault %q doesn't name the jump source", chip.Fault())
+	}
+}
+
+// TestProtectLow tests that fault mode refuses the STOR into low memory
+func TestProtectLow(t *testing.T) {
+	chip := NewChip(TESTDIR + "test_stor.bin")
+	chip.protectLow = protectFault
+	for i := 0; i < 3; i++ {
+		chip.Execute()
+	}
+	if chip.Fault() == nil {
+		t.Errorf("No fault after writing to %#x", chip.index)
+	}
+	if chip.memory[0xB] != 0 {
+		t.Errorf("Got %#x, expected the write to be refused", chip.memory[0xB])
+	}
+}
+
+// TestLoadImage tests that a 4KB file is loaded as a whole memory image
+func TestLoadImage(t *testing.T) {
+	image := make([]byte, memSize)
+	image[0x10] = 0xAA
+	image[defaultProgStart] = 0xBB
+	path := t.TempDir() + "/image.bin"
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	chip := NewChip(path)
+	if chip.memory[0x10] != 0xAA || chip.memory[defaultProgStart] != 0xBB {
+		t.Errorf("Got %#x and %#x, expected 0xAA and 0xBB", chip.memory[0x10], chip.memory[defaultProgStart])
+	}
+	if chip.memory[FONT_OFFSET] != 0 {
+		t.Errorf("Got font byte %#x, expected the image to replace it", chip.memory[FONT_OFFSET])
+	}
+}
+
+// TestDetectVariant tests the SCHIP/XO-CHIP opcode scan
+func TestDetectVariant(t *testing.T) {
+	tests := []struct {
+		prog    []uint8
+		variant string
+		first   int
+	}{
+		{[]uint8{0xA0, 0x0A, 0x61, 0xAB, 0xF1, 0x55}, "", 0},
+		{[]uint8{0x61, 0x01, 0x00, 0xFF, 0xD0, 0x10, 0x00, 0xFB}, "SCHIP", 2},
+		{[]uint8{0x00, 0xFF, 0x61, 0x01}, "", 0}, // one hit could be data
+		{[]uint8{0xF0, 0x00, 0x12, 0x34, 0x51, 0x23, 0xF1, 0x01}, "XO-CHIP", 0},
+	}
+	for _, tt := range tests {
+		v, first := detectVariant(tt.prog)
+		if v != tt.variant || first != tt.first {
+			t.Errorf("% x: got %q at %d, expected %q at %d", tt.prog, v, first, tt.variant, tt.first)
+		}
+	}
+}
+
+// TestDetectSettings tests that detection picks the variant and its quirks
+// but leaves flags that were given alone
+func TestDetectSettings(t *testing.T) {
+	schip := []uint8{0x61, 0x01, 0x00, 0xFF, 0xD0, 0x10, 0x00, 0xFB}
+	for _, tt := range []struct {
+		args      []string
+		variant   string
+		shift     bool
+		loadStore string
+	}{
+		{nil, "schip", true, "schip"},
+		{[]string{"-quirk-shift=false"}, "schip", false, "schip"},
+		{[]string{"-variant=chip8"}, "chip8", false, "vip"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var s settings
+		s.register(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		detectSettings(fs, schip)
+		if s.Variant != tt.variant || s.QuirkShift != tt.shift || s.QuirkLoadStore != tt.loadStore {
+			t.Errorf("%v: got variant %s, shift %v, load/store %s", tt.args, s.Variant, s.QuirkShift, s.QuirkLoadStore)
+		}
+	}
+}
+
+// TestReportUnsupported tests the exit summary of skipped and ignored opcodes
+func TestReportUnsupported(t *testing.T) {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.LoadROM([]uint8{0x00, 0xFB, 0x00, 0xFB, 0xE1, 0x23, 0xE1, 0x23})
+	for i := 0; i < 4; i++ {
+		chip.Execute()
+	}
+	var b strings.Builder
+	chip.reportUnsupported(&b)
+	expected := "ROM used 00FB (SCHIP scroll right; not part of CHIP-8) 2 times, first at 0x200\n" +
+		"ROM used E123 (not a CHIP-8 opcode) 2 times, first at 0x204\n"
+	if b.String() != expected {
+		t.Errorf("got %q, expected %q", b.String(), expected)
+	}
+}
+
+// TestUnknownOps tests that unknown instructions are stepped over by default
+// and stop the program with ErrUnknownOpcode when asked
+func TestUnknownOps(t *testing.T) {
+	for _, tt := range []struct {
+		mode   string
+		wantPC uint16
+		fault  bool
+	}{
+		{"skip", 0x208, false}, // steps over the zero word to the halt
+		{"halt", 0x202, true},
+	} {
+		chip := new(Chip8)
+		chip.trace = io.Discard
+		chip.Init()
+		set := defaultSettings()
+		set.UnknownOps = tt.mode
+		if err := set.apply(chip, new(frontendOpts)); err != nil {
+			t.Fatal(err)
+		}
+		chip.LoadROM([]uint8{0x61, 0x01, 0xF1, 0xFF, 0x62, 0x02, 0x00, 0x00, 0x12, 0x08})
+		chip.RunFrame()
+		var unknown *ErrUnknownOpcode
+		if got := errors.As(chip.fault, &unknown); got != tt.fault {
+			t.Errorf("%s: got fault %v", tt.mode, chip.fault)
+		} else if got && (unknown.PC != 0x202 || unknown.Inst != 0xF1FF) {
+			t.Errorf("%s: got %+v, expected F1FF at 0x202", tt.mode, *unknown)
+		}
+		if chip.pc != tt.wantPC {
+			t.Errorf("%s: got pc %#x, expected %#x", tt.mode, chip.pc, tt.wantPC)
+		}
+	}
+
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.unknownOps = unknownHalt
+	chip.RunFrame() // nothing loaded: zeroed memory
+	var unknown *ErrUnknownOpcode
+	if !errors.As(chip.fault, &unknown) || unknown.Inst != 0x0000 || unknown.PC != 0x200 {
+		t.Errorf("zeroed memory under halt: got fault %v", chip.fault)
+	}
+}
+
+// TestRunFrame tests that a frame runs the instruction budget and ticks timers once
+func TestRunFrame(t *testing.T) {
+	chip := NewChip(TESTDIR + "test_draw.bin")
+	chip.trace = io.Discard
+	chip.ipf = 4 // the ROM's four instructions, before the zeroed memory after
+	chip.delayTimer = 5
+	chip.soundTimer = 1
+	res, err := chip.RunFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chip.pc != 0x208 {
+		t.Errorf("Got pc %#x, expected 0x208", chip.pc)
+	}
+	if chip.delayTimer != 4 || chip.soundTimer != 0 {
+		t.Errorf("Got timers %d/%d, expected 4/0", chip.delayTimer, chip.soundTimer)
+	}
+	if !res.Drawn || res.Sound || res.Halted {
+		t.Errorf("Got %+v, expected only Drawn", res)
+	}
+	if res, _ = chip.RunFrame(); res.Drawn {
+		t.Errorf("Got Drawn on a frame with no DRAW")
+	}
+}
+
+// TestRunFrameAllocs tests that a frame with tracing off doesn't allocate
+func TestRunFrameAllocs(t *testing.T) {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.LoadROM([]uint8{
+		0x71, 0x01, // 200 ADD v1 0x1
+		0xA3, 0x00, // 202 LOADI 0x300
+		0xF1, 0x55, // 204 STOR v1
+		0xD0, 0x01, // 206 DRAW v0 v0 0x1
+		0x81, 0x04, // 208 ADDR v1 v0
+		0x00, 0xE0, // 20A CLR
+		0x12, 0x00, // 20C JUMP 0x200
+	})
+	chip.RunFrame()
+	if n := testing.AllocsPerRun(100, func() { chip.RunFrame() }); n != 0 {
+		t.Errorf("RunFrame made %v allocations, expected none", n)
+	}
+}
+
+// TestProfiler tests that frame timings are collected and reported per stage
+func TestProfiler(t *testing.T) {
+	chip := NewChip(TESTDIR + "test_draw.bin")
+	chip.trace = io.Discard
+	chip.prof = new(profiler)
+	for i := 0; i < 3; i++ {
+		chip.RunFrame()
+		chip.prof.endFrame()
+	}
+	if chip.prof.frames[stageExecute][0] == 0 {
+		t.Errorf("No execute time recorded for the first frame")
+	}
+	var b strings.Builder
+	chip.prof.report(&b)
+	if !strings.HasPrefix(b.String(), "3 frames") || !strings.Contains(b.String(), "\npresent ") {
+		t.Errorf("Got report %q", b.String())
+	}
+}
+
+// TestCheckpoint tests restoring a checkpoint and page sharing between checkpoints
+func TestCheckpoint(t *testing.T) {
+	chip := NewChip(TESTDIR + "test_read.bin")
+	chip.trace = io.Discard
+	before := chip.Checkpoint()
+	for i := 0; i < 5; i++ {
+		chip.Execute()
+	}
+	after := chip.Checkpoint()
+	if &after.pages[0][0] == &before.pages[0][0] {
+		t.Errorf("Page 0 was written by STOR but is shared")
+	}
+	if &after.pages[2][0] != &before.pages[2][0] {
+		t.Errorf("Page 2 was not written but was copied")
+	}
+	chip.Restore(before)
+	if chip.pc != defaultProgStart || chip.memory[0xB] != 0 || chip.v[1] != 0 {
+		t.Errorf("Got pc %#x, mem %#x, v1 %#x after restore, expected 0x200, 0, 0", chip.pc, chip.memory[0xB], chip.v[1])
+	}
+	chip.Restore(after)
+	if chip.memory[0xB] != 0xAB || chip.v[1] != 0xAB {
+		t.Errorf("Got mem %#x, v1 %#x after restore, expected 0xAB", chip.memory[0xB], chip.v[1])
+	}
+
+	// An FX75's flags and a pending FX0A come back as they were.
+	chip.rpl[3] = 0x42
+	chip.waiting, chip.vblank = true, false
+	chip.keyWait = keyWait{active: true, key: 5}
+	saved := chip.Checkpoint()
+	chip.rpl = [16]uint8{}
+	chip.waiting, chip.vblank = false, true
+	chip.keyWait = keyWait{}
+	chip.Restore(saved)
+	if chip.rpl[3] != 0x42 || !chip.waiting || chip.vblank || !chip.keyWait.active || chip.keyWait.key != 5 {
+		t.Errorf("Got rpl %v, waiting %v, vblank %v, key wait %+v after restore", chip.rpl, chip.waiting, chip.vblank, chip.keyWait)
+	}
+}
+
+// TestHandoff tests that a ROM handed off by another instance loads as the
+// first ROM would, with its bundle's settings or a detected variant, not
+// with the last ROM's
+func TestHandoff(t *testing.T) {
+	dir := t.TempDir()
+	schip := dir + "/scroll.ch8"
+	if err := os.WriteFile(schip, []byte{0x00, 0xFF, 0x00, 0xFB, 0x00, 0xFC, 0x00, 0xFE, 0x12, 0x08}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bundled := dir + "/draw" + bundleExt
+	if err := runBundle([]string{"-ipf", "20", "-o", bundled, TESTDIR + "test_draw.bin"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	handoff := make(chan string, 1)
+	opts := &frontendOpts{handoff: handoff, shot: shotStop, shotDir: dir}
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.ipf = 30 // as an earlier ROM's bundle had it
+
+	handoff <- schip
+	opts.pollHandoff(chip)
+	if chip.variant != variantSCHIP || chip.ipf == 30 {
+		t.Errorf("Handed %s: got variant %s, ipf %d, expected SCHIP by detection and the default speed", schip, variantNames[chip.variant], chip.ipf)
+	}
+	handoff <- bundled
+	opts.pollHandoff(chip)
+	if chip.variant == variantSCHIP || chip.ipf != 20 {
+		t.Errorf("Handed %s: got variant %s, ipf %d, expected the bundle's CHIP-8 at 20", bundled, variantNames[chip.variant], chip.ipf)
+	}
+	// The shots are named after the ROM now running and stamped with it.
+	rom, b, _ := readROM(bundled)
+	if want := newStopShot(shotStop, dir, bundled, rom, b.Settings); chip.shot == nil || chip.shot.path != want.path || *chip.shot.run != *want.run {
+		t.Errorf("Handed %s: got shot %+v, expected %+v", bundled, chip.shot, want)
+	}
+}
+
+// TestBundle tests that a bundle round-trips the ROM and its settings
+func TestBundle(t *testing.T) {
+	out := t.TempDir() + "/draw" + bundleExt
+	args := []string{"-ipf", "20", "-invert", "-o", out, TESTDIR + "test_draw.bin"}
+	if err := runBundle(args, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	rom, b, err := readROM(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := os.ReadFile(TESTDIR + "test_draw.bin")
+	if !bytes.Equal(rom, want) {
+		t.Errorf("Got ROM % x, expected % x", rom, want)
+	}
+	if b.Settings.IPF != 20 || !b.Settings.Invert || b.Settings.ProtectLow != "off" {
+		t.Errorf("Got settings %+v, expected ipf 20, inverted, defaults otherwise", b.Settings)
+	}
+
+	// Flags after the ROM count as well; bad settings, a bundle that wouldn't
+	// load as one and one written over its ROM are refused.
+	after := t.TempDir() + "/after" + bundleExt
+	if err := runBundle([]string{TESTDIR + "test_draw.bin", "-ipf", "30", "-o", after}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, b, err := readROM(after); err != nil || b.Settings.IPF != 30 {
+		t.Errorf("Got %+v, %v, expected a bundle at ipf 30 from flags after the ROM", b, err)
+	}
+	for _, args := range [][]string{
+		{"-variant", "chip9", "-o", after, TESTDIR + "test_draw.bin"},
+		{"-stack-depth", "0", "-o", after, TESTDIR + "test_draw.bin"},
+		{"-o", t.TempDir() + "/draw.c8b", TESTDIR + "test_draw.bin"},
+		{after},
+	} {
+		if err := runBundle(args, io.Discard); err == nil {
+			t.Errorf("runBundle %q: expected an error", args)
+		}
+	}
+}
+
+// TestBatch tests that the opcode report counts the ROMs each opcode ran
+// in, including those that were skipped, and that a directory's other files
+// are left out
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	roms := map[string][]uint8{
+		"a.ch8": {0x60, 0x01, 0x12, 0x02},             // LOAD v0 0x1; JUMP 0x202
+		"b.ch8": {0x00, 0xFF, 0x80, 0x08, 0x12, 0x04}, // SCHIP's hi-res, not an opcode, JUMP 0x204
+		"notes": {0x60, 0x01, 0x60, 0x01, 0x12, 0x04},
+	}
+	for name, rom := range roms {
+		if err := os.WriteFile(dir+"/"+name, rom, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := runBatch([]string{"-opcode-report", "csv", dir}, &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	want := "opcode,summary,roms,executed,unsupported_roms\n" +
+		"1NNN,jump to NNN; a jump to itself halts,2,2,0\n" +
+		"00FF,SCHIP: switch to the 128x64 display,1,1,1\n" +
+		"6XNN,VX = NN,1,1,0\n" +
+		"8008,not an opcode,1,1,1\n"
+	if out.String() != want {
+		t.Errorf("got report\n%s\nexpected\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := runBatch([]string{"-opcode-report=json", dir + "/a.ch8"}, &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	var lines []opcodeUse
+	if err := json.Unmarshal(out.Bytes(), &lines); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != (opcodeUse{"1NNN", "jump to NNN; a jump to itself halts", 1, 1, 0}) {
+		t.Errorf("got %+v", lines)
+	}
+	if err := runBatch([]string{"-opcode-report=xml", dir}, io.Discard, io.Discard); err == nil {
+		t.Error("no error for an unknown report format")
+	}
+}
+
+// TestKeymap tests that -keymap moves keypad keys, keeps the defaults it
+// doesn't touch, rejects bad entries and reads back what it prints
+func TestKeymap(t *testing.T) {
+	keys, err := parseKeymap("5=up 5=W, 8=down # arrows\n7=x", "qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1=1 2=2 3=3 C=4\n" +
+		"4=q 5=w 5=up 6=e D=r\n" +
+		"7=x 8=down 9=d E=f\n" +
+		"A=z 0= B=c F=v\n"
+	if got := keys.format("qwerty"); got != want {
+		t.Errorf("got keymap\n%s\nexpected\n%s", got, want)
+	}
+	again, err := parseKeymap(keys.format("qwerty"), "qwerty")
+	if err != nil || !maps.Equal(again, keys) {
+		t.Errorf("got %v (%v) reading back %v", again, err, keys)
+	}
+	if def, _ := parseKeymap("", "dvorak"); !maps.Equal(def, keypadKeys) {
+		t.Errorf("got %v for no keymap", def)
+	}
+	for _, bad := range []string{"5=numpad", "G=a", "5", "5=a 6=a"} {
+		if _, err := parseKeymap(bad, "qwerty"); err == nil {
+			t.Errorf("no error for keymap %q", bad)
+		}
+	}
+
+	path := t.TempDir() + "/arrows.keys"
+	os.WriteFile(path, []byte("2=up\n8=down\n"), 0o644)
+	var set settings
+	fs := flag.NewFlagSet("keymap", flag.ContinueOnError)
+	set.register(fs)
+	if err := fs.Parse([]string{"-keymap", path}); err != nil {
+		t.Fatal(err)
+	}
+	opts := new(frontendOpts)
+	if err := set.apply(new(Chip8), opts); err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := opts.keys["down"]; !ok || k != 0x8 {
+		t.Errorf("got down = %X, %v from the file", k, ok)
+	}
+}
+
+// TestKeyboards tests that -keyboard names keys by their labels on each
+// layout while the keys stay where they are
+func TestKeyboards(t *testing.T) {
+	for name, labels := range keyboards {
+		if n, want := len(strings.Fields(labels)), len(strings.Fields(keyRows)); n != want {
+			t.Errorf("%s labels %d keys, expected %d", name, n, want)
+		}
+	}
+	for _, tc := range []struct {
+		keyboard, spec, at string // at is the US name of the key 5 ends up on
+	}{
+		{"qwerty", "5=z", "z"},
+		{"azerty", "5=z", "w"},
+		{"azerty", "5=m", "semicolon"},
+		{"qwertz", "5=z", "y"},
+		{"qwertz", "5=minus", "slash"},
+		{"dvorak", "5=comma", "w"},
+		{"dvorak", "5=s", "semicolon"},
+	} {
+		keys, err := parseKeymap(tc.spec, tc.keyboard)
+		if err != nil {
+			t.Errorf("%s %s: %v", tc.keyboard, tc.spec, err)
+			continue
+		}
+		if k, ok := keys[tc.at]; !ok || k != 0x5 {
+			t.Errorf("%s %s: got %v, expected 5 on %s", tc.keyboard, tc.spec, keys, tc.at)
+		}
+	}
+	def, _ := parseKeymap("", "azerty")
+	if got := def.format("azerty"); !strings.HasPrefix(got, "1=1 2=2 3=3 C=4\n4=a 5=z 6=e D=r\n7=q") {
+		t.Errorf("got AZERTY defaults\n%s", got)
+	}
+	if _, err := parseKeymap("5=slash", "azerty"); err == nil {
+		t.Error("no error for a key AZERTY has no name for")
+	}
+	if _, err := parseKeymap("", "colemak"); err == nil {
+		t.Error("no error for an unknown keyboard")
+	}
+}
+
+// TestButtons tests that -buttons moves keys between controller buttons
+// as -keymap does between keyboard keys
+func TestButtons(t *testing.T) {
+	def, err := parseButtons("")
+	if err != nil || !maps.Equal(def, padButtons) {
+		t.Errorf("got %v (%v) for no -buttons", def, err)
+	}
+	got, err := parseButtons("2=up 8=down, 1=Start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := keymap{"up": 0x2, "down": 0x8, "start": 0x1, "left": 0x7, "right": 0x9, "a": 0x6, "b": 0x4}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+	for _, bad := range []string{"5=z", "5=up 6=up", "up=5"} {
+		if _, err := parseButtons(bad); err == nil {
+			t.Errorf("no error for buttons %q", bad)
+		}
+	}
+}
+
+// TestWatch tests that -watch applies a changed bundle's speed and keys at
+// once, keeps a display toggle the file didn't change and holds quirk
+// changes back until a restart
+func TestWatch(t *testing.T) {
+	path := t.TempDir() + "/game" + bundleExt
+	rom := []uint8{0x12, 0x00} // JUMP 0x200
+	write := func(set settings, at time.Time) {
+		b, _ := json.Marshal(bundle{ROM: rom, Settings: set})
+		os.WriteFile(path, b, 0o644)
+		os.Chtimes(path, at, at)
+	}
+	set := defaultSettings()
+	start := time.Now()
+	write(set, start)
+	chip := new(Chip8)
+	chip.Init()
+	opts := new(frontendOpts)
+	set.apply(chip, opts)
+	chip.LoadROM(rom)
+	opts.watch = newBundleWatch(path, rom, set, func(s settings) settings { return s })
+	opts.display.invert = true // as F1 would
+
+	set.IPF, set.Keymap = 30, "5=up"
+	write(set, start.Add(time.Second))
+	if opts.pollWatch(chip, start.Add(2*time.Second)) {
+		t.Error("speed and keys asked for a restart")
+	}
+	if chip.ipf != 30 || opts.keys["up"] != 0x5 || !opts.display.invert {
+		t.Errorf("got ipf %d, keys %v, invert %v", chip.ipf, opts.keys, opts.display.invert)
+	}
+
+	set.QuirkShift = true
+	write(set, start.Add(3*time.Second))
+	if opts.pollWatch(chip, start.Add(2500*time.Millisecond)) {
+		t.Error("looked again before a second was up")
+	}
+	if !opts.pollWatch(chip, start.Add(4*time.Second)) {
+		t.Fatal("a quirk change didn't ask for a restart")
+	}
+	if chip.quirks.Shift {
+		t.Error("the quirk changed before the restart")
+	}
+	opts.restart(chip)
+	if !chip.quirks.Shift || chip.ipf != 30 || opts.watch.pending {
+		t.Errorf("after the restart got quirks %+v, ipf %d, pending %v", chip.quirks, chip.ipf, opts.watch.pending)
+	}
+}
+
+// TestStackDepth tests that the configured depth sizes the stack and survives Init
+func TestStackDepth(t *testing.T) {
+	chip := new(Chip8)
+	chip.Init()
+	set := defaultSettings()
+	set.StackDepth = 32
+	if err := set.apply(chip, new(frontendOpts)); err != nil {
+		t.Fatal(err)
+	}
+	chip.Init()
+	if len(chip.stack) != 32 {
+		t.Errorf("Got depth %d, expected 32", len(chip.stack))
+	}
+	set.StackDepth = 0
+	if err := set.apply(chip, new(frontendOpts)); err == nil {
+		t.Errorf("No error for a zero stack depth")
+	}
+}
+
+// TestWarnUninit tests that reading memory nothing has written is reported
+// once, and that the ROM, the font and earlier writes count as written
+func TestWarnUninit(t *testing.T) {
+	var b strings.Builder
+	diag = &b
+	defer func() { diag = os.Stderr }()
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.warnUninit = true
+	chip.LoadROM([]uint8{
+		0xA0, 0x50, // 200 LOADI 0x50, the font
+		0xF0, 0x65, // 202 READ v0
+		0xA2, 0x00, // 204 LOADI 0x200, the ROM
+		0xF0, 0x65, // 206 READ v0
+		0xA3, 0x00, // 208 LOADI 0x300
+		0xF1, 0x65, // 20A READ v1, never written
+		0xA3, 0x10, // 20C LOADI 0x310
+		0xF0, 0x55, // 20E STOR v0
+		0xA3, 0x10, // 210 LOADI 0x310
+		0xF0, 0x65, // 212 READ v0, written just now
+		0xA3, 0x00, // 214 LOADI 0x300
+		0xF0, 0x65, // 216 READ v0, already reported
+	})
+	for i := 0; i < 12; i++ {
+		chip.Execute()
+	}
+	expected := "pc 0x20a read 0x300, which nothing has loaded or written\n" +
+		"pc 0x20a read 0x301, which nothing has loaded or written\n"
+	if b.String() != expected {
+		t.Errorf("got %q, expected %q", b.String(), expected)
+	}
+}
+
+// TestStartAddr tests that an ETI-660 program loads and runs at 0x600, and
+// that the start address survives Init
+func TestStartAddr(t *testing.T) {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	set := defaultSettings()
+	set.StartAddr = 0x600
+	set.ProtectExec = true
+	if err := set.apply(chip, new(frontendOpts)); err != nil {
+		t.Fatal(err)
+	}
+	chip.Init()
+	chip.LoadROM([]uint8{
+		0x61, 0xAB, // 600 LOAD v1 0xAB
+		0x12, 0x00, // 602 JUMP 0x200, now inside the interpreter area
+	})
+	chip.Execute()
+	if chip.v[1] != 0xAB {
+		t.Errorf("Got v1 %#x, expected the program to run from 0x600", chip.v[1])
+	}
+	chip.Execute()
+	chip.Execute()
+	if chip.Fault() == nil {
+		t.Errorf("No fault after jumping below the start address")
+	}
+	set.StartAddr = memSize
+	if err := set.apply(chip, new(frontendOpts)); err == nil {
+		t.Errorf("No error for a start address past the end of memory")
+	}
+}
+
+// TestInferSymbols tests naming of subroutines, jump targets and data
+func TestInferSymbols(t *testing.T) {
+	rom := []uint8{
+		0xA2, 0x0C, // 200 LOADI 0x20C
+		0x22, 0x06, // 202 CALL 0x206
+		0x12, 0x04, // 204 JUMP 0x204
+		0x30, 0x01, // 206 SKE v0 0x1
+		0x00, 0xEE, // 208 RET
+		0x00, 0xEE, // 20A RET
+		0xF0, 0x90, // 20C sprite data
+	}
+	got := inferSymbols(rom, defaultProgStart)
+	want := symbols{0x204: "label_204", 0x206: "sub_206", 0x20C: "data_20C"}
+	if len(got) != len(want) {
+		t.Errorf("Got %v, expected %v", got, want)
+	}
+	for addr, name := range want {
+		if got[addr] != name {
+			t.Errorf("Got %q at %#x, expected %q", got[addr], addr, name)
+		}
+	}
+}
+
+// TestLint tests each kind of problem the linter reports
+func TestLint(t *testing.T) {
+	rom := []uint8{
+		0x22, 0x0A, // 200 CALL 0x20A
+		0x32, 0x00, // 202 SKE v2 0x0, v2 set only on one path
+		0x62, 0x01, // 204 LOAD v2 0x1
+		0x82, 0x14, // 206 ADDR v2 v1
+		0x12, 0x00, // 208 JUMP 0x200
+		0x61, 0x05, // 20A LOAD v1 0x5
+		0x00, 0xEE, // 20C RET
+		0x12, 0x34, // 20E never reached
+		0x00, 0x00, // 210 padding
+	}
+	got := lintROM(rom, defaultProgStart, Quirks{})
+	want := []lintIssue{
+		{0x202, "reads v2, which isn't set on every path here"},
+		{0x206, "reads v2, which isn't set on every path here"},
+		{0x20E, "2 bytes never run and never loaded into I"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+
+	rom = []uint8{
+		0x60, 0x00, // 200 LOAD v0 0x0
+		0x30, 0x00, // 202 SKE v0 0x0
+		0x00, 0xEE, // 204 RET, with nothing to return to
+		0x22, 0x08, // 206 CALL 0x208
+		0x12, 0x06, // 208 JUMP 0x206, never returning
+	}
+	got = lintROM(rom, defaultProgStart, Quirks{})
+	want = []lintIssue{
+		{0x204, "RET without a CALL"},
+		{0x206, "CALL can nest more than 16 deep; does a subroutine jump back instead of returning?"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+}
+
+// TestSymbolFile tests that hand-made names survive re-running the analysis
+func TestSymbolFile(t *testing.T) {
+	dir := t.TempDir()
+	bin, _ := os.ReadFile(TESTDIR + "test_halt.bin")
+	os.WriteFile(dir+"/halt.ch8", bin, 0o644)
+	os.WriteFile(dir+"/halt.sym", []byte("202 done # renamed\n"), 0o644)
+	if err := runSymbols([]string{dir + "/halt.ch8"}, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	syms, err := readSymbols(dir + "/halt.sym")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syms[0x202] != "done" {
+		t.Errorf("Got %q at 0x202, expected the hand-made name", syms[0x202])
+	}
+
+	// Loaded at 0x600, the same ROM's names move with it.
+	os.WriteFile(dir+"/eti.ch8", []byte{0x26, 0x04, 0x16, 0x02, 0x00, 0xEE}, 0o644) // CALL 0x604, JUMP 0x602, RET
+	if err := runSymbols([]string{"-start-addr", "0x600", dir + "/eti.ch8"}, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if syms, _ = readSymbols(dir + "/eti.sym"); syms[0x604] != "sub_604" || syms[0x602] != "label_602" {
+		t.Errorf("Got %v at -start-addr 0x600, expected sub_604 and label_602", syms)
+	}
+}
+
+// TestTraceSampling tests that sampling keeps every Nth instruction and all control flow
+func TestTraceSampling(t *testing.T) {
+	var buf bytes.Buffer
+	chip := NewChip(TESTDIR + "test_read.bin")
+	chip.trace = &buf
+	chip.traceEvery = 4
+	for i := 0; i < 6; i++ {
+		chip.Execute()
+	}
+	// Instructions 0 and 4, LOADI and READ; test_read has no control flow.
+	if got := strings.Count(buf.String(), "Chip State"); got != 2 {
+		t.Errorf("Got %d traced instructions, expected 2", got)
+	}
+
+	buf.Reset()
+	chip = NewChip(TESTDIR + "test_halt.bin")
+	chip.trace = &buf
+	chip.traceEvery = 100
+	chip.Execute()
+	chip.Execute()
+	if got := strings.Count(buf.String(), "inst: 0x1202"); got != 1 {
+		t.Errorf("Got %d traces of the jump, expected 1", got)
+	}
+}
+
+// TestLimitWriter tests that the trace stops at its byte budget
+func TestLimitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &limitWriter{w: &buf, max: 10}
+	fmt.Fprint(w, "12345")
+	fmt.Fprint(w, "67890abc")
+	fmt.Fprint(w, "more")
+	if buf.String() != "12345trace truncated after 10 bytes\n" {
+		t.Errorf("Got %q", buf.String())
+	}
+}
+
+// TestAudit tests that identical runs match and that differing random
+// numbers are caught on the frame they first show up
+func TestAudit(t *testing.T) {
+	rom := []uint8{
+		0xC1, 0xFF, // 200 RAND v1 0xFF
+		0x12, 0x02, // 202 JUMP 0x202
+	}
+	same := func() randSource { return fixedRand(7) }
+	if frame, diff, _ := auditROM(rom, defaultSettings(), 10, same); frame != -1 {
+		t.Errorf("Got divergence in frame %d (%v) with identical randomness", frame, diff)
+	}
+	var n uint32
+	differ := func() randSource { n++; return fixedRand(n) }
+	frame, diff, _ := auditROM(rom, defaultSettings(), 10, differ)
+	if frame != 0 || len(diff) != 1 || diff[0] != "registers" {
+		t.Errorf("Got divergence in frame %d (%v), expected frame 0 in registers", frame, diff)
+	}
+}
+
+// TestRotatingFile tests that the log moves aside once it would pass its limit
+func TestRotatingFile(t *testing.T) {
+	path := t.TempDir() + "/hapax8.log"
+	f, err := openRotating(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(f, "first\n")
+	fmt.Fprint(f, "second\n")
+	f.Close()
+	old, _ := os.ReadFile(path + ".1")
+	cur, _ := os.ReadFile(path)
+	if string(old) != "first\n" || string(cur) != "second\n" {
+		t.Errorf("Got %q and %q, expected the first line rotated out", old, cur)
+	}
+}
+
+// TestBinaryTrace tests that the compressed trace decodes to the text trace
+func TestBinaryTrace(t *testing.T) {
+	var text, bin, decoded bytes.Buffer
+	chip := NewChip(TESTDIR + "test_read.bin")
+	chip.trace = &text
+	chip.binTrace = newBinaryTrace(&bin)
+	for i := 0; i < 6; i++ {
+		chip.Execute()
+	}
+	chip.SetPC(0x300) // exercise a non-sequential pc
+	chip.memory[0x300] = 0x61
+	chip.Execute()
+	if err := chip.binTrace.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := catTrace(&bin, &decoded, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The binary trace has the state dumps, not the opcodes' own messages.
+	want := strings.ReplaceAll(text.String(), "clear screen\n", "")
+	if decoded.String() != want {
+		t.Errorf("Got\n%s\nexpected\n%s", decoded.String(), want)
+	}
+}
+
+// TestStateFormat tests -state-format in the trace and trace cat, and that
+// bad formats are refused before anything runs
+func TestStateFormat(t *testing.T) {
+	format, err := parseStateFormat(`{{printf "%03X" .PC}} {{index .V 1}} {{len .Stack}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text, bin, decoded bytes.Buffer
+	chip := newVariant(t, "chip8",
+		0x61, 0x07, // 200 LOAD v1 7
+		0x22, 0x06, // 202 CALL 0x206
+		0x00, 0x00,
+		0x71, 0x01, // 206 ADD v1 1
+	)
+	chip.trace = &text
+	chip.stateFormat = format
+	chip.binTrace = newBinaryTrace(&bin)
+	for i := 0; i < 3; i++ {
+		chip.Execute()
+	}
+	if want := "200 0 0\n202 7 0\n206 7 1\n"; text.String() != want {
+		t.Errorf("got trace %q, expected %q", text.String(), want)
+	}
+	if err := chip.binTrace.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := catTrace(&bin, &decoded, format); err != nil {
+		t.Fatal(err)
+	}
+	// A binary trace doesn't keep the stack's contents.
+	if want := "200 0 0\n202 7 0\n206 7 0\n"; decoded.String() != want {
+		t.Errorf("got decoded trace %q, expected %q", decoded.String(), want)
+	}
+
+	for _, bad := range []string{"{{.PC", "{{.Nope}}", "{{index .V 16}}"} {
+		if _, err := parseStateFormat(bad); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+// TestOpcodeTable checks every table entry is reachable, so an earlier,
+// broader mask can't shadow it
+func TestOpcodeTable(t *testing.T) {
+	for i, op := range opcodes {
+		if got := lookupOpcode(op.match); got != &opcodes[i] {
+			t.Errorf("%s is shadowed by %s", op.pattern, got.pattern)
+		}
+	}
+	var b strings.Builder
+	printOpcodes(&b, "8xy6")
+	if !strings.HasPrefix(b.String(), "8XY6  SHR") {
+		t.Errorf("help for 8XY6 = %q", b.String())
+	}
+}
+
+// TestAssemble checks the built-in assembler against the chip8asm-built test ROMs
+func TestAssemble(t *testing.T) {
+	for _, name := range []string{"test_stor", "test_read", "test_draw", "test_halt", "test_protect", "test_call"} {
+		src, err := os.ReadFile("./test_asm/" + name + ".asm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bin, err := os.ReadFile(TESTDIR + name + ".bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		for _, line := range strings.Split(string(src), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			op, err := assemble(line)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			got = append(got, byte(op>>8), byte(op))
+		}
+		if !bytes.Equal(got, bin) {
+			t.Errorf("%s: got % x, expected % x", name, got, bin)
+		}
+	}
+}
+
+// TestREPLFaults tests that the REPL reports and clears a fault, and
+// refuses an opcode where there's no room for one, rather than panicking
+func TestREPLFaults(t *testing.T) {
+	var out bytes.Buffer
+	repl(strings.NewReader("RET\n6105\nJUMP 0xFFF\n6207\nreset\n6309\n"), &out)
+	got := out.String()
+	for _, want := range []string{"fault:", "regs: [0 5 0", "pc 0xfff leaves no room", "regs: [0 0 0 9"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+// TestREPLWaitKey tests that FX0A in the REPL asks for a key, again if the
+// answer isn't one, and puts it in VX
+func TestREPLWaitKey(t *testing.T) {
+	var out bytes.Buffer
+	repl(strings.NewReader("F30A\nx\nb\n"), &out)
+	got := out.String()
+	if n := strings.Count(got, "key (0-F)? "); n != 2 {
+		t.Errorf("asked for a key %d times, expected 2:\n%s", n, got)
+	}
+	if !strings.Contains(got, "regs: [0 0 0 11 ") || !strings.Contains(got, "pc: 0x202") {
+		t.Errorf("output lacks V3 = 0xB with FX0A done:\n%s", got)
+	}
+}
+
+// TestExport tests that each frame's display arrives over UDP in both formats
+func TestExport(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback UDP:", err)
+	}
+	defer l.Close()
+	oscHeader := "/hapax8/frame\x00\x00\x00,b\x00\x00\x00\x00\x01\x00"
+	for _, tt := range []struct {
+		format string
+		header string
+	}{
+		{"raw", ""},
+		{"osc", oscHeader},
+	} {
+		format, err := parseExportFormat(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chip := NewChip(TESTDIR + "test_draw.bin") // draws the 0 glyph at (1, 1)
+		chip.trace = io.Discard
+		e, err := newFrameExporter(l.LocalAddr().String(), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chip.exports = []*frameExporter{e}
+		chip.RunFrame()
+		e.Close()
+		buf := make([]byte, 1024)
+		l.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := l.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, pix := string(buf[:len(tt.header)]), buf[len(tt.header):n]
+		if header != tt.header || len(pix) != displayWidth*displayHeight/8 {
+			t.Errorf("%s: got a %d byte packet starting %q", tt.format, n, header)
+			continue
+		}
+		// Rows 1-5 of the glyph, shifted right one pixel, in the first byte.
+		for row, want := range []byte{0, 0x78, 0x48, 0x48, 0x48, 0x78, 0} {
+			if got := pix[row*displayWidth/8]; got != want {
+				t.Errorf("%s: row %d starts %#x, expected %#x", tt.format, row, got, want)
+			}
+		}
+	}
+}
+
+// TestSerialExport tests that a serial device only gets frames that drew,
+// each after the sync header
+func TestSerialExport(t *testing.T) {
+	dev := t.TempDir() + "/tty"
+	if err := os.WriteFile(dev, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := newSerialExporter(dev, exportFramed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.LoadROM([]uint8{
+		0xA0, 0x50, // 200 LOADI 0x50, the 0 glyph
+		0xD0, 0x01, // 202 DRAW v0 v0 0x1
+		0x12, 0x04, // 204 JUMP 0x204
+	})
+	chip.exports = []*frameExporter{e}
+	for i := 0; i < 3; i++ {
+		chip.RunFrame()
+	}
+	e.Close()
+	got, err := os.ReadFile(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(frameSync)+displayWidth*displayHeight/8 || string(got[:len(frameSync)]) != frameSync {
+		t.Fatalf("got % x", got)
+	}
+	if p := got[len(frameSync)]; p != 0xF0 {
+		t.Errorf("got first byte %#x, expected 0xf0", p)
+	}
+}
+
+// testPad is a keypad reporting a fixed sequence of masks.
+type testPad []uint16
+
+func (p *testPad) Keys() (uint16, error) {
+	if len(*p) == 0 {
+		return 0, errors.New("unplugged")
+	}
+	mask := (*p)[0]
+	*p = (*p)[1:]
+	return mask, nil
+}
+
+// TestPadInput tests that extra keypads are read as each frame starts and
+// share the chip with the frontend's keys
+func TestPadInput(t *testing.T) {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	pad := &testPad{1<<0x5 | 1<<0xA, 1 << 0xA}
+	opts := &frontendOpts{pads: []*padInput{{pad: pad}}}
+	chip.SetInput(opts.input(&scriptInput{steps: []uint16{1 << 0x1}})) // the frontend's keyboard
+	chip.RunFrame()
+	if keys := chip.pad(); !keys[0x1] || !keys[0x5] || !keys[0xA] {
+		t.Errorf("after first frame got keys %v", keys)
+	}
+	chip.RunFrame()
+	if keys := chip.pad(); !keys[0x1] || keys[0x5] || !keys[0xA] {
+		t.Errorf("after second frame got keys %v", keys)
+	}
+	chip.RunFrame()
+	if !opts.pads[0].failed {
+		t.Error("read error didn't disable the pad")
+	}
+	if keys := chip.pad(); !keys[0x1] || keys[0xA] {
+		t.Errorf("after the pad failed got keys %v", keys)
+	}
+}
+
+// newVariant returns a chip running the given -variant with prog loaded.
+func newVariant(t *testing.T, variant string, prog ...uint8) *Chip8 {
+	t.Helper()
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	set := defaultSettings()
+	set.Variant = variant
+	if err := set.apply(chip, new(frontendOpts)); err != nil {
+		t.Fatal(err)
+	}
+	chip.LoadROM(prog)
+	return chip
+}
+
+// TestSCHIPDisplay tests hi-res mode, 16x16 sprites and scrolling
+func TestSCHIPDisplay(t *testing.T) {
+	chip := newVariant(t, "schip",
+		0x00, 0xFF, // 200 EXTE
+		0xA3, 0x00, // 202 LOADI 0x300
+		0x60, 0x70, // 204 LOAD v0 0x70
+		0xD0, 0x10, // 206 DRAW v0 v1 0x0
+		0x00, 0xC2, // 208 SCRD 0x2
+		0x00, 0xFB, // 20A SCRR
+		0x00, 0xFC, // 20C SCRL
+		0x00, 0xFC, // 20E SCRL
+	)
+	for i := 0; i < 16; i++ {
+		chip.memory[0x300+2*i] = 0x80 // column 0
+		chip.memory[0x301+2*i] = 0x01 // column 15
+	}
+	for i := 0; i < 4; i++ {
+		chip.Execute()
+	}
+	if w, h := chip.DisplaySize(); w != 128 || h != 64 {
+		t.Fatalf("Got display %dx%d, expected 128x64", w, h)
+	}
+	if !chip.Pixel(0x70, 15) || !chip.Pixel(0x7F, 15) || chip.Pixel(0x70, 16) {
+		t.Error("16x16 sprite not drawn at (0x70, 0)")
+	}
+	for _, tt := range []struct {
+		step string
+		x, y int // a pixel of column 0 that should now be lit
+	}{
+		{"down 2", 0x70, 17},
+		{"right 4", 0x74, 17},
+		{"left 4", 0x70, 17},
+		{"left 4", 0x6C, 17},
+	} {
+		chip.Execute()
+		if !chip.Pixel(tt.x, tt.y) || chip.Pixel(tt.x, 1) {
+			t.Errorf("after scrolling %s: pixel (%#x, %d) off or row 1 lit", tt.step, tt.x, tt.y)
+		}
+	}
+	// SCRR pushed column 15 off the right edge, so it scrolls back in blank.
+	if chip.Pixel(0x7B, 17) {
+		t.Error("scrolling left brought back a pixel scrolled off the edge")
+	}
+
+	cp := chip.Checkpoint()
+	chip.LoadROM([]uint8{0x00, 0xFE}) // EXTD
+	chip.pc = 0x200
+	chip.Execute()
+	if w, _ := chip.DisplaySize(); w != 64 || chip.Pixel(0x0C, 17) {
+		t.Errorf("Got width %d after EXTD, expected 64 and a clear display", w)
+	}
+	chip.Restore(cp)
+	if w, _ := chip.DisplaySize(); w != 128 || !chip.Pixel(0x6C, 17) {
+		t.Errorf("Restore didn't bring back the hi-res display")
+	}
+}
+
+// TestSCHIPOps tests the big font, RPL flags and exit, and that plain CHIP-8
+// treats them all as unsupported
+func TestSCHIPOps(t *testing.T) {
+	prog := []uint8{
+		0x61, 0x07, // 200 LOAD v1 0x7
+		0xF1, 0x30, // 202 FX30: I = big 7
+		0xF1, 0x75, // 204 SRPL v1
+		0x00, 0xE0, // 206 CLR
+		0x61, 0x00, // 208 LOAD v1 0x0
+		0xF1, 0x85, // 20A LRPL v1
+		0x00, 0xFD, // 20C EXIT
+	}
+	chip := newVariant(t, "schip", prog...)
+	chip.v[0] = 0x42
+	for i := 0; i < 7; i++ {
+		chip.Execute()
+	}
+	if chip.index != BIG_FONT_OFFSET+70 || chip.memory[chip.index+4] != 0x06 {
+		t.Errorf("Got I %#x for the big 7", chip.index)
+	}
+	if chip.v[0] != 0x42 || chip.v[1] != 0x07 {
+		t.Errorf("Got V0 %#x, V1 %#x back from the RPL flags, expected 0x42, 0x7", chip.v[0], chip.v[1])
+	}
+	if !chip.Halted() || chip.pc != 0x20C {
+		t.Errorf("EXIT didn't halt (pc %#x)", chip.pc)
+	}
+
+	chip = new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.LoadROM(prog)
+	for i := 0; i < 7; i++ {
+		chip.Execute()
+	}
+	if chip.index != 0 || chip.Halted() || len(chip.unsupported) != 4 {
+		t.Errorf("CHIP-8 ran SCHIP opcodes: I %#x, halted %v, %d unsupported", chip.index, chip.Halted(), len(chip.unsupported))
+	}
+}
+
+// TestResumed tests that a long wall-clock gap between frames is taken as a
+// suspend, releasing the extra keypads' keys
+func TestResumed(t *testing.T) {
+	pad := &testPad{1 << 0x3}
+	opts := &frontendOpts{pads: []*padInput{{pad: pad, last: 1 << 0x3}}}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		after time.Duration
+		want  bool
+	}{
+		{0, false}, // the first frame has nothing to compare with
+		{frameTime, false},
+		{time.Second, false},
+		{10 * time.Minute, true},
+		{frameTime, false},
+	} {
+		start = start.Add(tt.after)
+		opts.pads[0].last = 1 << 0x3
+		if got := opts.resumed(start); got != tt.want {
+			t.Errorf("after %v: got %v, expected %v", tt.after, got, tt.want)
+		}
+		if down := opts.pads[0].Pressed(0x3); down == tt.want {
+			t.Errorf("after %v: key 3 down %v", tt.after, down)
+		}
+	}
+}
+
+// TestXOCHIP tests the long index load and the skips over it, register
+// ranges and 64KB of memory
+func TestXOCHIP(t *testing.T) {
+	chip := newVariant(t, "xochip",
+		0x30, 0x00, // 200 SKE v0 0x0
+		0xF0, 0x00, 0x12, 0x34, // 202 I = 0x1234, skipped
+		0xF0, 0x00, 0xFF, 0x00, // 206 I = 0xFF00
+		0x51, 0x32, // 20A save V1..V3
+		0x56, 0x43, // 20C load V6 down to V4
+	)
+	if len(chip.memory) != 0x10000 {
+		t.Fatalf("Got %d bytes of memory, expected 64KB", len(chip.memory))
+	}
+	chip.v[1], chip.v[2], chip.v[3] = 1, 2, 3
+	chip.Execute()
+	if chip.pc != 0x206 {
+		t.Fatalf("Got pc %#x after the skip, expected 0x206", chip.pc)
+	}
+	for i := 0; i < 3; i++ {
+		chip.Execute()
+	}
+	if chip.index != 0xFF00 || !slices.Equal(chip.memory[0xFF00:0xFF03], []uint8{1, 2, 3}) {
+		t.Errorf("Got I %#x, % x at 0xFF00", chip.index, chip.memory[0xFF00:0xFF03])
+	}
+	if chip.v[6] != 1 || chip.v[5] != 2 || chip.v[4] != 3 || chip.index != 0xFF00 {
+		t.Errorf("Got V4..V6 %v, I %#x", chip.v[4:7], chip.index)
+	}
+}
+
+// TestXOCHIPPlanes tests drawing, clearing and scrolling with the two
+// display planes
+func TestXOCHIPPlanes(t *testing.T) {
+	chip := newVariant(t, "xochip",
+		0xA3, 0x00, // 200 LOADI 0x300
+		0xF3, 0x01, // 202 planes 1 and 2
+		0xD0, 0x11, // 204 DRAW v0 v1 0x1: 0x300 to plane 1, 0x301 to plane 2
+		0xF2, 0x01, // 206 plane 2
+		0x00, 0xD1, // 208 scroll plane 2 up 1
+		0xF1, 0x01, // 20A plane 1
+		0x00, 0xE0, // 20C CLR plane 1
+	)
+	chip.memory[0x300] = 0xC0
+	chip.memory[0x301] = 0x60
+	chip.v[1] = 1
+	for _, tt := range []struct {
+		step string
+		n    int
+		want string // Framebuffer indexes of the first three pixels of rows 0 and 1
+	}{
+		{"draw", 3, "000 132"},
+		{"scroll up", 2, "022 110"},
+		{"clear", 2, "022 000"},
+	} {
+		for i := 0; i < tt.n; i++ {
+			chip.Execute()
+		}
+		img := chip.Framebuffer().(*image.Paletted)
+		got := fmt.Sprintf("%d%d%d %d%d%d", img.Pix[0], img.Pix[1], img.Pix[2],
+			img.Pix[img.Stride], img.Pix[img.Stride+1], img.Pix[img.Stride+2])
+		if got != tt.want {
+			t.Errorf("after %s: got pixels %s, expected %s", tt.step, got, tt.want)
+		}
+	}
+}
+
+// TestXOCHIPAudio tests that a loaded pattern replaces the buzzer
+func TestXOCHIPAudio(t *testing.T) {
+	chip := newVariant(t, "xochip", 0xA3, 0x00, 0xF0, 0x02) // LOADI 0x300, load pattern
+	for i := 0; i < 16; i++ {
+		chip.memory[0x300+i] = 0xF0 // 4 high bits, 4 low
+	}
+	chip.Execute()
+	chip.Execute()
+	chip.pitch = 160 // 16000 bits a second, about 2.76 samples a bit
+	buf := make([]float32, audioRate/60)
+	chip.ReadAudio(buf)
+	chip.soundTimer = 2
+	chip.RunFrame()
+	chip.ReadAudio(buf)
+	var got strings.Builder
+	for _, v := range buf[:24] {
+		if v > 0 {
+			got.WriteByte('+')
+		} else {
+			got.WriteByte('-')
+		}
+	}
+	if want := "++++++++++++-----------+"; got.String() != want {
+		t.Errorf("got %s, expected %s", got.String(), want)
+	}
+}
+
+// TestQuirks tests the logic VF reset and sprite wrapping quirks against
+// the default behavior
+func TestQuirks(t *testing.T) {
+	for _, tt := range []struct {
+		quirks  Quirks
+		vf      uint8
+		wrapped bool
+	}{
+		{Quirks{}, 1, false},
+		{Quirks{LogicResetVF: true}, 0, false},
+		{Quirks{Wrap: true}, 1, true},
+	} {
+		chip := new(Chip8)
+		chip.trace = io.Discard
+		chip.Init()
+		chip.quirks = tt.quirks
+		chip.LoadROM([]uint8{
+			0x8F, 0x11, // 200 OR vF v1
+			0xA0, 0x50, // 202 LOADI 0x50, the 0 glyph
+			0xD2, 0x32, // 204 DRAW v2 v3 0x2, over the bottom right corner
+		})
+		chip.v[0xF], chip.v[1] = 1, 1
+		chip.v[2], chip.v[3] = 62, 31
+		chip.Execute()
+		if chip.v[0xF] != tt.vf {
+			t.Errorf("%+v: got VF %d after OR, expected %d", tt.quirks, chip.v[0xF], tt.vf)
+		}
+		chip.Execute()
+		chip.Execute()
+		// The glyph's second row, 0x90, lands on row 0 at x = 1 when wrapped.
+		if !chip.Pixel(63, 31) || chip.Pixel(0, 31) != tt.wrapped || chip.Pixel(1, 0) != tt.wrapped {
+			t.Errorf("%+v: sprite wrapped %v, expected %v", tt.quirks, chip.Pixel(1, 0), tt.wrapped)
+		}
+	}
+}
+
+// TestDisplayWait tests that the display wait quirk draws one sprite a
+// frame, leaving the PC on the next DXYN until the frame after
+func TestDisplayWait(t *testing.T) {
+	for _, tt := range []struct {
+		wait bool
+		pcs  []uint16 // after each frame
+	}{
+		{false, []uint16{0x206, 0x206}},
+		{true, []uint16{0x202, 0x204, 0x206}},
+	} {
+		chip := new(Chip8)
+		chip.trace = io.Discard
+		chip.Init()
+		chip.quirks.DisplayWait = tt.wait
+		chip.LoadROM([]uint8{0xD0, 0x01, 0xD0, 0x01, 0xD0, 0x01, 0x12, 0x06}) // DRAW v0 v0 0x1, three times, then halt
+		for i, want := range tt.pcs {
+			chip.RunFrame()
+			if chip.pc != want {
+				t.Errorf("wait %v: got pc %#x after frame %d, expected %#x", tt.wait, chip.pc, i, want)
+			}
+		}
+	}
+}
+
+// TestStrict tests that -strict faults on a read past the end of memory,
+// which otherwise wraps around, and halts on unknown opcodes
+func TestStrict(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		chip := new(Chip8)
+		chip.trace = io.Discard
+		chip.Init()
+		set := defaultSettings()
+		set.Strict = strict
+		if err := set.apply(chip, new(frontendOpts)); err != nil {
+			t.Fatal(err)
+		}
+		chip.LoadROM([]uint8{
+			0xAF, 0xFF, // 200 LOADI 0xFFF
+			0xF1, 0x65, // 202 READ v1, from 0xFFF and 0x000
+		})
+		chip.memory[0] = 0xAB
+		chip.Execute()
+		chip.Execute()
+		if got := chip.Fault() != nil; got != strict {
+			t.Errorf("strict %v: got fault %v", strict, chip.Fault())
+		}
+		if !strict && chip.v[1] != 0xAB {
+			t.Errorf("got v1 %#x, expected the read to wrap to 0xAB", chip.v[1])
+		}
+		if strict && (chip.unknownOps != unknownHalt || chip.protectLow != protectFault || !chip.protectExec) {
+			t.Errorf("strict didn't turn on every check: %v %v %v", chip.unknownOps, chip.protectLow, chip.protectExec)
+		}
+	}
+}
+
+// TestBatchShots tests that "hapax8 batch -shot-on" saves a shot of each ROM
+// that stops in the current directory, out of the way of the ROMs
+func TestBatchShots(t *testing.T) {
+	dir, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(dir+"/a.ch8", []uint8{0x61, 0x2A, 0x12, 0x02}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(out); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := runBatch([]string{"-shot-on", "stop", dir}, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	state, err := os.ReadFile(out + "/a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(state), `"reason": "halted"`) || !strings.Contains(string(state), `"rom_sha256"`) {
+		t.Errorf("got state %s", state)
+	}
+	if err := runBatch([]string{"-shot-on", "always", dir}, io.Discard, io.Discard); err == nil {
+		t.Error("expected an error for -shot-on always")
+	}
+}
+
+// TestStopShot tests that a halt saves the display and state once under
+// -shot-on=stop, and that -shot-on=fault leaves halts alone
+func TestStopShot(t *testing.T) {
+	for _, mode := range []shotMode{shotFault, shotStop} {
+		chip := new(Chip8)
+		chip.trace = io.Discard
+		chip.Init()
+		path := t.TempDir() + "/rom"
+		rom := []uint8{0x61, 0x2A, 0x12, 0x02} // LOAD v1 0x2A; JUMP 0x202
+		chip.shot = &stopShot{mode: mode, path: path, run: newRunStamp(rom, defaultSettings())}
+		chip.LoadROM(rom)
+		chip.RunFrame()
+		state, err := os.ReadFile(path + ".json")
+		if mode == shotFault {
+			if err == nil {
+				t.Error("fault mode saved a shot for a halt")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(state), `"reason": "halted"`) || !strings.Contains(string(state), `"pc": 514`) {
+			t.Errorf("got state %s", state)
+		}
+		var saved stopState
+		if err := json.Unmarshal(state, &saved); err != nil || saved.Run == nil {
+			t.Fatalf("got no run stamp in %s (%v)", state, err)
+		}
+		// The digests are of the ROM and the default settings, so a checker
+		// can recompute them.
+		if want := newRunStamp(rom, defaultSettings()); *saved.Run != *want {
+			t.Errorf("got run %+v, expected %+v", *saved.Run, *want)
+		}
+		if other := newRunStamp(rom, settings{IPF: 20}); other.SettingsDigest == saved.Run.SettingsDigest {
+			t.Error("different settings got the same digest")
+		}
+		f, err := os.Open(path + ".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width != displayWidth || cfg.Height != displayHeight {
+			t.Errorf("got a %dx%d PNG (%v), expected the 64x32 display", cfg.Width, cfg.Height, err)
+		}
+	}
+}
+
+// TestCHIP8X tests zone colors, the background, nibble adds and the second
+// keypad, and that programs load at 0x300
+func TestCHIP8X(t *testing.T) {
+	chip := newVariant(t, "chip8x",
+		0x64, 0x10, // 300 LOAD v4 0x10, zones 0 and 1 across
+		0x65, 0x00, // 302 LOAD v5 0x0, the top zone
+		0x66, 0x01, // 304 LOAD v6 0x1, red
+		0xB4, 0x60, // 306 BXYN v4 v6 0
+		0x02, 0xA0, // 308 background to black
+		0x67, 0x75, // 30A LOAD v7 0x75
+		0x68, 0x13, // 30C LOAD v8 0x13
+		0x57, 0x81, // 30E 5XY1 v7 v8
+		0x69, 0x03, // 310 LOAD v9 0x3
+		0xE9, 0xF2, // 312 EXF2 v9
+	)
+	chip.SetKey2(3, true)
+	for i := 0; i < 10; i++ {
+		chip.Execute()
+	}
+	red, black := chip8xColors[1], chip8xColors[0]
+	if on, _ := chip.Colors(15, 3); on != red {
+		t.Errorf("got %v in zone (1, 0), expected red", on)
+	}
+	if on, off := chip.Colors(16, 4); on != color.White || off != black {
+		t.Errorf("got %v on %v outside the colored zones, expected white on black", on, off)
+	}
+	if chip.v[7] != 0x00 {
+		t.Errorf("got v7 %#x, expected each nibble to wrap at 8", chip.v[7])
+	}
+	if chip.pc != 0x316 {
+		t.Errorf("got pc %#x, expected EXF2 to skip with key 3 down on the second keypad", chip.pc)
+	}
+
+	chip = newVariant(t, "chip8", 0x02, 0xA0, 0xB3, 0x00) // 200 02A0; 202 JUMPI 0x300
+	chip.Execute()
+	chip.Execute()
+	if chip.background != 0 || chip.pc != 0x300 {
+		t.Errorf("got background %d and pc %#x, expected plain CHIP-8 to ignore 02A0 and jump", chip.background, chip.pc)
+	}
+}
+
+// TestPaletteHook tests -colors and -hue-cycle
+func TestPaletteHook(t *testing.T) {
+	hook, err := newPaletteHook("#00FF00,#000080", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recolor := hook(1)
+	if got := recolor(color.White); got != (color.RGBA{0x00, 0xFF, 0x00, 0xFF}) {
+		t.Errorf("got lit %v, expected green", got)
+	}
+	if got := recolor(color.Black); got != (color.RGBA{0x00, 0x00, 0x80, 0xFF}) {
+		t.Errorf("got unlit %v, expected navy", got)
+	}
+
+	hook, _ = newPaletteHook("", 3)
+	red := color.RGBA{0xFF, 0x00, 0x00, 0xFF}
+	if got := hook(180)(red); got != red {
+		t.Errorf("got %v after a whole turn, expected red again", got)
+	}
+	if r, g, b, _ := hook(60)(red).RGBA(); r > g || r > b {
+		t.Errorf("got %v a third of a turn on, expected red to have turned", hook(60)(red))
+	}
+	if got := hook(45)(color.White); got != (color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("got %v, expected white to stay white", got)
+	}
+
+	if hook, _ := newPaletteHook("", 0); hook != nil {
+		t.Error("got a hook with nothing to do")
+	}
+	if _, err := newPaletteHook("#FFFFFF", 0); err == nil {
+		t.Error("no error for a single color")
+	}
+}
+
+// TestFilters tests that -filter chains registered filters after -colors,
+// in order
+func TestFilters(t *testing.T) {
+	registerFilter("test-dim", func(uint64) func(color.Color) color.Color {
+		return func(c color.Color) color.Color {
+			r, g, b, a := c.RGBA()
+			return color.RGBA{uint8(r >> 9), uint8(g >> 9), uint8(b >> 9), uint8(a >> 8)}
+		}
+	})
+	defer delete(filters, "test-dim")
+
+	set := defaultSettings()
+	set.Colors, set.Filters = "#FF0000,#000000", "gray,test-dim"
+	hook, err := set.palette()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Red, then its luminance, then halved.
+	if got := color.RGBAModel.Convert(hook(1)(color.White)); got != (color.RGBA{0x26, 0x26, 0x26, 0xFF}) {
+		t.Errorf("got %v for lit", got)
+	}
+	set.Filters = "sepia"
+	if _, err := set.palette(); err == nil {
+		t.Error("no error for an unknown filter")
+	}
+}
+
+// TestOSD tests that messages wrap, sit at the bottom of the display in the
+// hex font and its extension, and that notices time out
+func TestOSD(t *testing.T) {
+	if got, want := osdWrap("paused after sleep: press a key", 12), []string{"paused after", "sleep: press", "a key"}; !slices.Equal(got, want) {
+		t.Errorf("wrap = %q, want %q", got, want)
+	}
+	if got, want := osdWrap("abcdefghijklmn", 12), []string{"abcdefghijkl", "mn"}; !slices.Equal(got, want) {
+		t.Errorf("long word wrap = %q, want %q", got, want)
+	}
+	if got, want := osdGlyph('a'), fontSet[50:55]; !slices.Equal(got, want) {
+		t.Errorf("glyph a = % X, want the font's A % X", got, want)
+	}
+	if got, want := osdGlyph('~'), osdGlyph('?'); !slices.Equal(got, want) {
+		t.Errorf("glyph ~ = % X, want ? % X", got, want)
+	}
+
+	var o osd
+	now := time.Now()
+	if m := o.render(64, 32, now); m != nil {
+		t.Fatal("render with nothing up isn't nil")
+	}
+	o.show(asleepText, 0, now)
+	m := o.render(64, 32, now)
+	// Three lines of six pixels fill the bottom 18 rows.
+	for _, tc := range []struct {
+		x, y int
+		want uint8
+	}{
+		{0, 13, osdNone},
+		{0, 14, osdPaper},
+		{0, 15, osdPaper},
+		{1, 15, osdInk}, // P's top row
+		{4, 15, osdInk},
+		{5, 15, osdPaper},
+		{1, 27, osdInk},    // A on the last line
+		{63, 31, osdPaper}, // right of the text
+	} {
+		if got := m[tc.y*64+tc.x]; got != tc.want {
+			t.Errorf("pixel %d,%d = %d, want %d", tc.x, tc.y, got, tc.want)
+		}
+	}
+	if on, covered := osdCell(m, 64, 1, 15); !on || !covered {
+		t.Errorf("osdCell on text = %v, %v", on, covered)
+	}
+	if _, covered := osdCell(m, 64, 0, 0); covered {
+		t.Error("osdCell above the box is covered")
+	}
+
+	o.show("invert on", osdNotice, now)
+	if o.render(64, 32, now.Add(osdNotice/2)) == nil {
+		t.Error("notice gone before its time")
+	}
+	if o.render(64, 32, now.Add(osdNotice)) != nil {
+		t.Error("notice still up after its time")
+	}
+}
+
+// TestMegaChip tests the 256x192 display, palettes, byte-per-pixel sprites
+// shown by 00E0, collisions and the 24-bit I
+func TestMegaChip(t *testing.T) {
+	chip := newVariant(t, "megachip",
+		0x00, 0x11, // 200 MegaChip mode on
+		0x03, 0x02, // 202 sprites 2 wide
+		0x04, 0x01, // 204 and 1 high
+		0x01, 0x01, 0x00, 0x00, // 206 I = 0x10000
+		0x02, 0x02, // 20A two colors from I
+		0x01, 0x01, 0x00, 0x08, // 20C I = 0x10008
+		0x60, 0x05, // 210 LOAD v0 5
+		0x61, 0x03, // 212 LOAD v1 3
+		0xD0, 0x11, // 214 DRAW v0 v1 1
+		0x09, 0x01, // 216 collisions with color 1
+		0xD0, 0x11, // 218 DRAW v0 v1 1
+		0x00, 0xE0, // 21A show the frame
+	)
+	copy(chip.memory[0x10000:], []uint8{
+		0xFF, 0xFF, 0x00, 0x00, // red
+		0x80, 0x00, 0xFF, 0x00, // green, half transparent
+		0x01, 0x02, // the sprite
+	})
+	for i := 0; i < 11; i++ {
+		chip.Execute()
+	}
+	if w, h := chip.DisplaySize(); w != 256 || h != 192 {
+		t.Fatalf("got a %dx%d display, expected 256x192", w, h)
+	}
+	if chip.Pixel(5, 3) {
+		t.Error("sprite shown before 00E0")
+	}
+	if chip.v[0xF] != 1 {
+		t.Errorf("got VF %d, expected drawing over color 1 to collide", chip.v[0xF])
+	}
+	chip.Execute()
+	if !chip.Pixel(5, 3) || !chip.Pixel(6, 3) || chip.Pixel(7, 3) {
+		t.Error("sprite not shown by 00E0 where it was drawn")
+	}
+	if on, _ := chip.Colors(5, 3); on != (color.NRGBA{0xFF, 0x00, 0x00, 0xFF}) {
+		t.Errorf("got %v at (5, 3), expected red", on)
+	}
+	if on, _ := chip.Colors(6, 3); on != (color.NRGBA{0x00, 0xFF, 0x00, 0x80}) {
+		t.Errorf("got %v at (6, 3), expected half-transparent green", on)
+	}
+	if img := chip.Framebuffer().(*image.Paletted); img.ColorIndexAt(6, 3) != 2 {
+		t.Errorf("got framebuffer index %d at (6, 3), expected 2", img.ColorIndexAt(6, 3))
+	}
+	if chip.gfx[3*256+5] != 0 {
+		t.Error("00E0 didn't clear the frame being drawn")
+	}
+
+	if xs, ys := pixelEdges(256, 192, 640, 320); xs[0] != 107 || xs[256] != 533 || ys[192] != 320 {
+		t.Errorf("got columns %d to %d and %d rows, expected 256x192 centered in 640x320", xs[0], xs[256], ys[192])
+	}
+	if xs, _ := pixelEdges(64, 32, 640, 320); xs[0] != 0 || xs[1] != 10 {
+		t.Errorf("got the first column at %d to %d, expected 0 to 10", xs[0], xs[1])
+	}
+
+	chip = newVariant(t, "megachip", 0x06, 0x00) // 200 digitized sound
+	chip.Execute()
+	if chip.pc != 0x202 || chip.unsupported[0x0600] == nil {
+		t.Errorf("got pc %#x, expected 060N to be stepped over and reported", chip.pc)
+	}
+	chip = newVariant(t, "schip", 0x00, 0x11) // 200 MegaChip mode on
+	chip.Execute()
+	if w, _ := chip.DisplaySize(); w != 64 {
+		t.Errorf("got a %d-wide display, expected SCHIP to ignore 0011", w)
+	}
+}
+
+// TestMegaWrap tests that with the wrap quirk a MegaChip sprite taller
+// than the display, as 04NN with 00 gives, wraps round more than once
+// rather than drawing past the end
+func TestMegaWrap(t *testing.T) {
+	chip := newVariant(t, "megachip",
+		0x00, 0x11, // 200 MegaChip mode on
+		0x03, 0x01, // 202 sprites 1 wide
+		0x04, 0x00, // 204 and 256 high
+		0x01, 0x00, 0x03, 0x00, // 206 I = 0x300
+		0x60, 0x05, // 20A LOAD v0 5
+		0x61, 0xB4, // 20C LOAD v1 180
+		0xD0, 0x11, // 20E DRAW v0 v1 1
+	)
+	chip.quirks.Wrap = true
+	for i := 0; i < 256; i++ {
+		chip.memory[0x300+i] = uint8(i) | 1
+	}
+	for i := 0; i < 7; i++ {
+		chip.Execute()
+	}
+	// The sprite's first row is at 180 of 192, so it wraps to the top at
+	// its row 12, and again at its row 204, over what drew there first.
+	for _, tt := range []struct{ y, row int }{{180, 192}, {191, 203}, {0, 204}, {51, 255}, {52, 64}, {179, 191}} {
+		if got := chip.gfx[tt.y*256+5]; got != uint8(tt.row)|1 {
+			t.Errorf("got %d at (5, %d), expected the sprite's row %d", got, tt.y, tt.row)
+		}
+	}
+}
+
+// TestHiresCHIP8 tests the 0x1260 entry, the 64x64 display, 0230 and that
+// such ROMs are detected
+func TestHiresCHIP8(t *testing.T) {
+	rom := make([]uint8, 0xD2)
+	copy(rom, []uint8{0x12, 0x60}) // 200 the jump into the hi-res setup
+	copy(rom[0xC0:], []uint8{
+		0x60, 0x3F, // 2C0 LOAD v0 63
+		0x61, 0x3F, // 2C2 LOAD v1 63
+		0xA2, 0xD0, // 2C4 LOADI 0x2D0
+		0xD0, 0x11, // 2C6 DRAW v0 v1 1
+		0x02, 0x30, // 2C8 clear
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x80, // 2D0 one pixel
+	})
+	if v, _ := detectVariant(rom); v != "Hi-res CHIP-8" {
+		t.Errorf("detected %q, expected Hi-res CHIP-8", v)
+	}
+	chip := newVariant(t, "hires", rom...)
+	chip.Execute()
+	if chip.pc != 0x2C0 {
+		t.Fatalf("got pc %#x after the entry jump, expected 0x2c0", chip.pc)
+	}
 	for i := 0; i < 4; i++ {
 		chip.Execute()
 	}
-	if chip.v[2] != 0xAB {
-		t.Errorf("Got %#x, expected 0xAB", chip.v[2])
+	if w, h := chip.DisplaySize(); w != 64 || h != 64 {
+		t.Fatalf("got a %dx%d display, expected 64x64", w, h)
+	}
+	if !chip.Pixel(63, 63) {
+		t.Error("pixel (63, 63) not drawn")
+	}
+	chip.Execute()
+	if chip.Pixel(63, 63) {
+		t.Error("0230 didn't clear the display")
+	}
+
+	chip = newVariant(t, "chip8", rom...)
+	chip.Execute()
+	if chip.pc != 0x260 {
+		t.Errorf("got pc %#x, expected plain CHIP-8 to take the jump as it is", chip.pc)
 	}
 }
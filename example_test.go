@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// Load a ROM and run it for a few instructions without any frontend.
+func ExampleNewChip() {
+	chip := NewChip(TESTDIR + "test_read.bin")
+	chip.trace = io.Discard
+	for i := 0; i < 5; i++ {
+		chip.Execute()
+	}
+	fmt.Printf("V1 = %#x\n", chip.v[1])
+	// Output: V1 = 0xab
+}
+
+// Each call runs the instruction at the PC and advances it.
+func ExampleChip8_Execute() {
+	chip := NewChip(TESTDIR + "test_stor.bin")
+	chip.trace = io.Discard
+	chip.Execute() // LOADI 0xA
+	fmt.Printf("pc=%#x index=%#x\n", chip.pc, chip.index)
+	chip.Execute() // LOAD v1 0xAB
+	fmt.Printf("pc=%#x v1=%#x\n", chip.pc, chip.v[1])
+	// Output:
+	// pc=0x202 index=0xa
+	// pc=0x204 v1=0xab
+}
+
+// A headless run steps until the program halts on its final jump-to-self,
+// then inspects the display.
+func Example_headlessRun() {
+	chip := NewChip(TESTDIR + "test_halt.bin")
+	chip.trace = io.Discard
+	steps := 0
+	for !chip.Halted() {
+		chip.Execute()
+		steps++
+	}
+	fmt.Printf("halted at %#x after %d instructions, pixel (0,0) lit: %v\n", chip.pc, steps, chip.Pixel(0, 0))
+	// Output: halted at 0x202 after 2 instructions, pixel (0,0) lit: false
+}
+
+// Framebuffer hands the display to code with its own graphics stack.
+func ExampleChip8_Framebuffer() {
+	chip := NewChip(TESTDIR + "test_draw.bin")
+	chip.trace = io.Discard
+	for i := 0; i < 4; i++ {
+		chip.Execute() // ends by drawing the 0 glyph at (1, 1)
+	}
+	img := chip.Framebuffer().(*image.Paletted)
+	lit := 0
+	for _, p := range img.Pix {
+		lit += int(p)
+	}
+	fmt.Println(img.Bounds().Size(), lit, "pixels lit, (1,1) is", img.ColorIndexAt(1, 1))
+	// Output: (64,32) 14 pixels lit, (1,1) is 1
+}
+
+// Embedders with their own audio output pull the buzzer's samples after
+// each frame.
+func ExampleChip8_ReadAudio() {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	buf := make([]float32, audioRate/60)
+	chip.ReadAudio(buf) // start generating
+	chip.soundTimer = 1
+	for i := 0; i < 2; i++ {
+		chip.RunFrame()
+		n := chip.ReadAudio(buf)
+		fmt.Printf("frame %d: %d samples, first %v\n", i, n, buf[0])
+	}
+	// Output:
+	// frame 0: 735 samples, first 0.25
+	// frame 1: 735 samples, first 0
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// exportFormat selects how -export packets are laid out.
+type exportFormat int
+
+const (
+	exportRaw    exportFormat = iota // the packed display and nothing else
+	exportOSC                        // an OSC message carrying the packed display as a blob
+	exportFramed                     // frameSync then the packed display, for byte streams
+)
+
+func parseExportFormat(s string) (exportFormat, error) {
+	switch s {
+	case "raw":
+		return exportRaw, nil
+	case "osc":
+		return exportOSC, nil
+	case "framed":
+		return exportFramed, nil
+	}
+	return 0, fmt.Errorf("unknown export format %q (want raw, osc or framed)", s)
+}
+
+// exportAddress is the OSC address pattern frames are sent to.
+const exportAddress = "/hapax8/frame"
+
+// frameSync starts every framed packet. A serial link has no packet
+// boundaries, so the receiver hunts for it to find the start of a frame.
+const frameSync = "\xA5\x5AH8"
+
+// frameExporter sends the display to a UDP address or a serial device, so
+// LED matrices, flip-dot signs and projection rigs can mirror it. Each frame
+// is one packet with one bit per pixel, row by row, most significant bit
+// leftmost: 256 bytes, or 1024 in SCHIP hi-res.
+type frameExporter struct {
+	w         io.WriteCloser
+	format    exportFormat
+	drawnOnly bool // skip frames that didn't touch the display
+	pkt       []byte
+	reported  bool // a send error has been logged
+}
+
+// newFrameExporter sends a packet to addr every frame.
+func newFrameExporter(addr string, format exportFormat) (*frameExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &frameExporter{w: conn, format: format}, nil
+}
+
+// newSerialExporter writes frames to a serial device, already set to the
+// right speed with stty or similar. A whole frame every 60th of a second is
+// more than most serial links carry, so only frames that drew are sent.
+func newSerialExporter(path string, format exportFormat) (*frameExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &frameExporter{w: f, format: format, drawnOnly: true}, nil
+}
+
+// packDisplay appends the display to b one bit per pixel.
+func packDisplay(b []byte, gfx []uint8) []byte {
+	for i := 0; i < len(gfx); i += 8 {
+		var octet byte
+		for j, p := range gfx[i : i+8] {
+			if p != 0 {
+				octet |= 0x80 >> j
+			}
+		}
+		b = append(b, octet)
+	}
+	return b
+}
+
+// oscString appends s as an OSC string: NUL terminated and padded to a
+// multiple of four bytes.
+func oscString(b []byte, s string) []byte {
+	b = append(b, s...)
+	for n := 4 - len(s)%4; n > 0; n-- {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// packet builds the frame's packet, reusing the exporter's buffer.
+func (e *frameExporter) packet(gfx []uint8) []byte {
+	b := e.pkt[:0]
+	switch e.format {
+	case exportOSC:
+		b = oscString(b, exportAddress)
+		b = oscString(b, ",b")
+		b = binary.BigEndian.AppendUint32(b, uint32(len(gfx)/8))
+	case exportFramed:
+		b = append(b, frameSync...)
+	}
+	// The packed display is always a multiple of four bytes, so the OSC
+	// blob needs no padding.
+	b = packDisplay(b, gfx)
+	e.pkt = b
+	return b
+}
+
+// send exports the current display. Nobody listening isn't a reason to
+// stop the emulator, so errors are only logged, once.
+func (e *frameExporter) send(c *Chip8) {
+	if e.drawnOnly && !c.drawn {
+		return
+	}
+	if _, err := e.w.Write(e.packet(c.visible())); err != nil && !e.reported {
+		fmt.Fprintln(diag, "export:", err)
+		e.reported = true
+	}
+}
+
+func (e *frameExporter) Close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// romPicker is implemented by GUI frontends that can ask the user for a ROM
+// when none was given on the command line.
+type romPicker interface {
+	PickROM() (string, error)
+}
+
+// errNoChooser is returned when the OS has no file chooser we know how to run.
+var errNoChooser = errors.New("no file chooser available (install zenity or kdialog)")
+
+// nativeFileDialog asks the OS for a file to open: AppleScript on macOS, a
+// WinForms dialog through PowerShell on Windows, and zenity or kdialog
+// elsewhere. It returns "" if the user cancelled.
+func nativeFileDialog(title string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", `POSIX path of (choose file with prompt "`+title+`")`)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command",
+			`Add-Type -AssemblyName System.Windows.Forms;`+
+				`$d = New-Object System.Windows.Forms.OpenFileDialog;`+
+				`$d.Title = '`+title+`';`+
+				`$d.Filter = 'CHIP-8 ROMs (*.ch8;*.c8;*.bin)|*.ch8;*.c8;*.bin|All files (*.*)|*.*';`+
+				`if ($d.ShowDialog() -eq 'OK') { $d.FileName }`)
+	default:
+		if path, err := exec.LookPath("zenity"); err == nil {
+			cmd = exec.Command(path, "--file-selection", "--title="+title)
+		} else if path, err := exec.LookPath("kdialog"); err == nil {
+			cmd = exec.Command(path, "--getopenfilename", ".", "--title", title)
+		} else {
+			return "", errNoChooser
+		}
+	}
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return "", nil // every chooser above exits non-zero on cancel
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
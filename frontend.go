@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// frameTime is how long one emulated frame lasts.
+const frameTime = time.Second / 60
+
+// Frontend presents a running chip and feeds it input until the user quits.
+type Frontend interface {
+	Run(c *Chip8) error
+}
+
+// displayOpts holds the accessibility settings that can be toggled at runtime.
+type displayOpts struct {
+	invert bool // swap the on and off colors
+	grid   bool // outline every pixel so single cells stand out
+	flash  bool // flash a border while the sound timer runs
+
+	palette paletteHook // recolors each frame; nil draws the chip's own colors
+}
+
+// frontendOpts carries the command-line settings a frontend is opened with.
+// Frontends keep the pointer: display settings from a bundle picked in the
+// file chooser only arrive after the frontend is open.
+type frontendOpts struct {
+	display        displayOpts
+	pauseUnfocused bool
+	handoff        <-chan string // ROMs forwarded by other instances, nil unless -single
+	pads           []*padInput   // keypads besides the frontend's own keyboard
+	lastFrame      time.Time     // wall-clock time of the previous loop, to notice suspends
+	frames         uint64        // frames drawn, for the palette hook
+
+	osd osd // messages drawn over the display
+
+	keys    keymap       // the keyboard keys that are the hex keypad, from -keymap
+	buttons keymap       // the game controller buttons that are, from -buttons
+	watch   *bundleWatch // the bundle being run, if -watch is given
+
+	args     []string // the command line's flags, which every ROM's settings start from
+	noDetect bool     // -no-detect: run every ROM as -variant says
+	shot     shotMode // -shot-on, for every ROM run
+	shotDir  string   // where the shots go
+}
+
+// pixelEdges lays a w x h display out in an area aw x ah pixels, at the
+// largest scale that fits and centered across. xs[x] to xs[x+1] are the
+// columns pixel column x covers, and ys the same for rows. CHIP-8 and SCHIP
+// displays scale evenly into the windows; MegaChip's 256x192 doesn't, so
+// its pixels differ in size by one.
+func pixelEdges(w, h, aw, ah int) (xs, ys []int) {
+	num, den := aw, w
+	if w*ah < h*aw {
+		num, den = ah, h
+	}
+	left := (aw - w*num/den) / 2
+	xs, ys = make([]int, w+1), make([]int, h+1)
+	for x := range xs {
+		xs[x] = left + x*num/den
+	}
+	for y := range ys {
+		ys[y] = y * num / den
+	}
+	return xs, ys
+}
+
+// reportFault logs a fault the first time it is seen. The windowed
+// frontends keep showing the display afterwards so the state the program
+// stopped in stays visible.
+func reportFault(err error, reported *bool) {
+	if err != nil && !*reported {
+		fmt.Fprintln(diag, "fault:", err)
+		*reported = true
+	}
+}
+
+// pollHandoff restarts the chip on a ROM forwarded by another instance, if
+// one is waiting, loading it as the first ROM was. Frontends call it once
+// per loop.
+func (o *frontendOpts) pollHandoff(c *Chip8) {
+	select {
+	case path := <-o.handoff:
+		rom, b, err := readROM(path)
+		if err != nil {
+			fmt.Fprintln(diag, err)
+			return
+		}
+		set, err := o.start(c, rom, b)
+		if err != nil {
+			fmt.Fprintln(diag, path+":", err)
+			return
+		}
+		c.shot = newStopShot(o.shot, o.shotDir, path, rom, set)
+		o.watch = nil // -watch follows the bundle the run started with
+	default:
+	}
+}
+
+// keypad is a source of keypad state other than the frontend's keyboard,
+// such as a matrix keypad wired to GPIO pins.
+type keypad interface {
+	Keys() (uint16, error) // bit k is set while key k is down
+}
+
+// padInput is a keypad and what it last reported, as an Input: it reads the
+// keypad as each frame starts.
+type padInput struct {
+	pad    keypad
+	last   uint16
+	failed bool // a read error has been logged and the pad is ignored
+}
+
+func (p *padInput) startFrame() {
+	if p.failed {
+		return
+	}
+	mask, err := p.pad.Keys()
+	if err != nil {
+		fmt.Fprintln(diag, "keypad:", err)
+		p.failed = true
+		mask = 0
+	}
+	p.last = mask
+}
+
+func (p *padInput) Pressed(k uint8) bool {
+	return p.last&(1<<k) != 0
+}
+
+func (p *padInput) WaitKey() uint8 {
+	return waitKey(p)
+}
+
+// keypadOpeners open the keypads compiled into this binary, each from its
+// own flags. An opener returns nil if its keypad wasn't asked for.
+var keypadOpeners []func() (keypad, error)
+
+// input is the keypad the chip asks: own, the frontend's keyboard or
+// controllers, if it has any, and the extra keypads alongside.
+func (o *frontendOpts) input(own Input) Input {
+	var in inputs
+	if own != nil {
+		in = append(in, own)
+	}
+	for _, p := range o.pads {
+		in = append(in, p)
+	}
+	return in
+}
+
+// suspendGap is a gap between two loops long enough that the machine must
+// have been asleep; no slow frame takes this long.
+const suspendGap = 2 * time.Second
+
+// resumed reports whether the system was suspended since the previous call.
+// Frontends call it once per loop, paused or not, and pause until a key is
+// pressed when it returns true. Key releases made while asleep were never
+// seen, so every key is let go: frontends let go of the keys they follow,
+// and extra keypads report what's really held at their next read. The tickers that pace frames drop the ticks missed
+// while asleep, so nothing runs fast to catch up.
+func (o *frontendOpts) resumed(now time.Time) bool {
+	// Go's monotonic clock stops while the machine sleeps, so compare wall
+	// clock time.
+	now = now.Round(0)
+	last := o.lastFrame
+	o.lastFrame = now
+	if last.IsZero() || now.Sub(last) < suspendGap {
+		return false
+	}
+	fmt.Fprintf(diag, "resumed after %v asleep; keys released\n", now.Sub(last).Round(time.Second))
+	for _, p := range o.pads {
+		p.last = 0
+	}
+	return true
+}
+
+// asleepTitle is the window title while a frontend waits for a key after
+// the system resumes, and asleepText what the OSD says meanwhile.
+const (
+	asleepTitle = "hapax8 (paused after sleep: press a key)"
+	asleepText  = "paused after sleep: press a key"
+)
+
+type frontendEntry struct {
+	name     string
+	priority int // higher is preferred by "auto"
+	open     func(opts *frontendOpts) (Frontend, error)
+}
+
+// frontends holds every frontend compiled into this binary, best first.
+var frontends []frontendEntry
+
+// registerFrontend makes a frontend selectable by name. Each frontend file
+// calls it from init, so which ones exist is decided by build tags.
+func registerFrontend(name string, priority int, open func(opts *frontendOpts) (Frontend, error)) {
+	frontends = append(frontends, frontendEntry{name, priority, open})
+	sort.SliceStable(frontends, func(i, j int) bool {
+		return frontends[i].priority > frontends[j].priority
+	})
+}
+
+// frontendNames lists the compiled-in frontends, best first.
+func frontendNames() []string {
+	names := make([]string, len(frontends))
+	for i, f := range frontends {
+		names[i] = f.name
+	}
+	return names
+}
+
+// openFrontend opens the named frontend. "auto" tries each compiled-in
+// frontend in priority order and returns the first that opens.
+func openFrontend(name string, opts *frontendOpts) (Frontend, error) {
+	if name != "auto" {
+		for _, f := range frontends {
+			if f.name == name {
+				return f.open(opts)
+			}
+		}
+		return nil, fmt.Errorf("unknown frontend %q (have %s)", name, strings.Join(frontendNames(), ", "))
+	}
+	var errs []string
+	for _, f := range frontends {
+		fe, err := f.open(opts)
+		if err == nil {
+			return fe, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", f.name, err))
+	}
+	return nil, fmt.Errorf("no usable frontend: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,226 @@
+//go:build ebiten || wasm
+
+package main
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+func init() {
+	registerFrontend("ebiten", 15, openEbiten)
+}
+
+// ebitenScale is screen pixels per display pixel.
+const ebitenScale = 10
+
+// ebitenKeys gives the keyNames in ebiten's terms. ebiten's keys are
+// physical ones, so a keymap is the same keys on any layout.
+var ebitenKeys = map[ebiten.Key]string{
+	ebiten.Key1: "1", ebiten.Key2: "2", ebiten.Key3: "3", ebiten.Key4: "4", ebiten.Key5: "5",
+	ebiten.Key6: "6", ebiten.Key7: "7", ebiten.Key8: "8", ebiten.Key9: "9", ebiten.Key0: "0",
+	ebiten.KeyA: "a", ebiten.KeyB: "b", ebiten.KeyC: "c", ebiten.KeyD: "d", ebiten.KeyE: "e",
+	ebiten.KeyF: "f", ebiten.KeyG: "g", ebiten.KeyH: "h", ebiten.KeyI: "i", ebiten.KeyJ: "j",
+	ebiten.KeyK: "k", ebiten.KeyL: "l", ebiten.KeyM: "m", ebiten.KeyN: "n", ebiten.KeyO: "o",
+	ebiten.KeyP: "p", ebiten.KeyQ: "q", ebiten.KeyR: "r", ebiten.KeyS: "s", ebiten.KeyT: "t",
+	ebiten.KeyU: "u", ebiten.KeyV: "v", ebiten.KeyW: "w", ebiten.KeyX: "x", ebiten.KeyY: "y",
+	ebiten.KeyZ: "z", ebiten.KeyMinus: "minus", ebiten.KeyEqual: "equals", ebiten.KeyComma: "comma",
+	ebiten.KeyPeriod: "period", ebiten.KeySlash: "slash", ebiten.KeySemicolon: "semicolon",
+	ebiten.KeyQuote: "apostrophe", ebiten.KeySpace: "space", ebiten.KeyEnter: "enter",
+	ebiten.KeyTab: "tab", ebiten.KeyBackspace: "backspace",
+	ebiten.KeyArrowUp: "up", ebiten.KeyArrowDown: "down", ebiten.KeyArrowLeft: "left", ebiten.KeyArrowRight: "right",
+	ebiten.KeyShiftLeft: "lshift", ebiten.KeyShiftRight: "rshift", ebiten.KeyControlLeft: "lctrl",
+	ebiten.KeyControlRight: "rctrl", ebiten.KeyAltLeft: "lalt", ebiten.KeyAltRight: "ralt",
+}
+
+// ebitenKeys2 puts the CHIP-8X second keypad on the numeric keypad: the
+// digits are themselves, / * - + Enter and . are A to F.
+var ebitenKeys2 = map[ebiten.Key]uint8{
+	ebiten.KeyNumpad0: 0x0, ebiten.KeyNumpad1: 0x1, ebiten.KeyNumpad2: 0x2, ebiten.KeyNumpad3: 0x3,
+	ebiten.KeyNumpad4: 0x4, ebiten.KeyNumpad5: 0x5, ebiten.KeyNumpad6: 0x6, ebiten.KeyNumpad7: 0x7,
+	ebiten.KeyNumpad8: 0x8, ebiten.KeyNumpad9: 0x9, ebiten.KeyNumpadDivide: 0xA, ebiten.KeyNumpadMultiply: 0xB,
+	ebiten.KeyNumpadSubtract: 0xC, ebiten.KeyNumpadAdd: 0xD, ebiten.KeyNumpadEnter: 0xE, ebiten.KeyNumpadDecimal: 0xF,
+}
+
+// ebitenInput is the keyboard as the chip's Input: the keymap's keys, or
+// for the second keypad ebitenKeys2's, looked up as the chip asks. ebiten
+// keeps track of what's held.
+type ebitenInput struct {
+	opts   *frontendOpts
+	second bool
+}
+
+func (in ebitenInput) Pressed(k uint8) bool {
+	if in.second {
+		for key, key2 := range ebitenKeys2 {
+			if key2 == k && ebiten.IsKeyPressed(key) {
+				return true
+			}
+		}
+		return false
+	}
+	for key, name := range ebitenKeys {
+		if km, ok := in.opts.keys[name]; ok && km == k && ebiten.IsKeyPressed(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitKey waits for ebiten, running its game loop meanwhile, to see a key go
+// down.
+func (in ebitenInput) WaitKey() uint8 {
+	return waitKey(in)
+}
+
+// ebitenFrontend runs the chip inside an ebiten game loop. It is also the
+// frontend used for js/wasm builds.
+type ebitenFrontend struct {
+	opts     *frontendOpts
+	chip     *Chip8
+	pix      []byte
+	faulted  bool
+	sounding bool
+	asleep   bool // paused after a system suspend until a key is pressed
+}
+
+func openEbiten(opts *frontendOpts) (Frontend, error) {
+	return &ebitenFrontend{opts: opts}, nil
+}
+
+// PickROM shows the native file chooser.
+func (f *ebitenFrontend) PickROM() (string, error) {
+	return nativeFileDialog("Open CHIP-8 ROM")
+}
+
+// Run executes the chip until the window is closed.
+func (f *ebitenFrontend) Run(chip *Chip8) error {
+	f.chip = chip
+	chip.SetInput(f.opts.input(ebitenInput{opts: f.opts}))
+	chip.SetInput2(ebitenInput{opts: f.opts, second: true})
+	defer chip.SetInput(nil)
+	defer chip.SetInput2(nil)
+	w, h := f.Layout(0, 0)
+	f.pix = make([]byte, w*h*4)
+	ebiten.SetWindowTitle("hapax8")
+	ebiten.SetWindowSize(w, h)
+	ebiten.SetRunnableOnUnfocused(!f.opts.pauseUnfocused)
+	return ebiten.RunGame(f)
+}
+
+// Update implements ebiten.Game.
+func (f *ebitenFrontend) Update() error {
+	d := &f.opts.display
+	if inpututil.IsKeyJustPressed(ebiten.KeyF1) {
+		d.invert = !d.invert
+		f.opts.toggled("invert", d.invert)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF2) {
+		d.grid = !d.grid
+		f.opts.toggled("grid", d.grid)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		d.flash = !d.flash
+		f.opts.toggled("sound flash", d.flash)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF5) {
+		f.opts.restart(f.chip)
+	}
+	if f.opts.resumed(time.Now()) {
+		f.asleep = true
+		ebiten.SetWindowTitle(asleepTitle)
+		f.opts.osd.show(asleepText, 0, time.Now())
+	}
+	if f.asleep {
+		if len(inpututil.AppendJustPressedKeys(nil)) == 0 {
+			return nil
+		}
+		f.asleep = false
+		ebiten.SetWindowTitle("hapax8")
+		f.opts.osd.hide()
+	}
+	f.opts.pollHandoff(f.chip)
+	f.opts.pollWatch(f.chip, time.Now())
+	// ebiten calls Update at 60Hz, one emulated frame each.
+	res, err := f.chip.RunFrame()
+	reportFault(err, &f.faulted)
+	f.sounding = res.Sound
+	return nil
+}
+
+// Draw implements ebiten.Game.
+func (f *ebitenFrontend) Draw(screen *ebiten.Image) {
+	w, _ := f.Layout(0, 0)
+	opts := f.opts.display
+	t := f.chip.prof.start()
+	recolor := f.opts.recolor()
+	// The window stays the same size; hi-res pixels are half as big.
+	dw, dh := f.chip.DisplaySize()
+	xs, ys := pixelEdges(dw, dh, w, displayHeight*ebitenScale)
+	mask := f.opts.osd.render(dw, dh, time.Now())
+	if xs[0] > 0 {
+		// A MegaChip display is narrower than the window; blank either side.
+		for y := 0; y < ys[dh]; y++ {
+			for x := 0; x < w; x++ {
+				if x < xs[0] || x >= xs[dw] {
+					i := (y*w + x) * 4
+					f.pix[i], f.pix[i+1], f.pix[i+2], f.pix[i+3] = 0, 0, 0, 255
+				}
+			}
+		}
+	}
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			lit, unlit := f.chip.Colors(x, y)
+			on, covered := osdCell(mask, dw, x, y)
+			if covered {
+				lit, unlit = color.White, color.Black
+			} else {
+				on = f.chip.Pixel(x, y)
+			}
+			if recolor != nil {
+				lit, unlit = recolor(lit), recolor(unlit)
+			}
+			c := unlit
+			if on != opts.invert {
+				c = lit
+			}
+			r, g, b, _ := c.RGBA()
+			x0, x1, y0, y1 := xs[x], xs[x+1], ys[y], ys[y+1]
+			grid := opts.grid && x1-x0 > 2 && y1-y0 > 2
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					i := (py*w + px) * 4
+					if grid && (px == x0 || py == y0 || px == x1-1 || py == y1-1) {
+						f.pix[i], f.pix[i+1], f.pix[i+2], f.pix[i+3] = 80, 80, 80, 255
+						continue
+					}
+					f.pix[i], f.pix[i+1], f.pix[i+2], f.pix[i+3] = byte(r>>8), byte(g>>8), byte(b>>8), 255
+				}
+			}
+		}
+	}
+	if opts.flash && f.sounding {
+		h := displayHeight * ebitenScale
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if x < 2 || y < 2 || x >= w-2 || y >= h-2 {
+					i := (y*w + x) * 4
+					f.pix[i], f.pix[i+1], f.pix[i+2] = 255, 176, 0
+				}
+			}
+		}
+	}
+	t = f.chip.prof.lap(stageDraw, t)
+	screen.WritePixels(f.pix)
+	f.chip.prof.lap(stagePresent, t)
+	f.chip.prof.endFrame()
+}
+
+// Layout implements ebiten.Game.
+func (f *ebitenFrontend) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return displayWidth * ebitenScale, displayHeight * ebitenScale
+}
@@ -0,0 +1,398 @@
+//go:build sdl || (cgo && !tty && !ebiten && !wasm)
+
+package main
+
+import (
+	"image/color"
+	"math/bits"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+func init() {
+	registerFrontend("sdl", 20, openSDL)
+}
+
+// sdlKeys gives the keyNames by scancode, so a keymap is the same keys on
+// layouts other than QWERTY: on AZERTY the default block starts at A Z E R.
+var sdlKeys = map[sdl.Scancode]string{
+	sdl.SCANCODE_1: "1", sdl.SCANCODE_2: "2", sdl.SCANCODE_3: "3", sdl.SCANCODE_4: "4", sdl.SCANCODE_5: "5",
+	sdl.SCANCODE_6: "6", sdl.SCANCODE_7: "7", sdl.SCANCODE_8: "8", sdl.SCANCODE_9: "9", sdl.SCANCODE_0: "0",
+	sdl.SCANCODE_A: "a", sdl.SCANCODE_B: "b", sdl.SCANCODE_C: "c", sdl.SCANCODE_D: "d", sdl.SCANCODE_E: "e",
+	sdl.SCANCODE_F: "f", sdl.SCANCODE_G: "g", sdl.SCANCODE_H: "h", sdl.SCANCODE_I: "i", sdl.SCANCODE_J: "j",
+	sdl.SCANCODE_K: "k", sdl.SCANCODE_L: "l", sdl.SCANCODE_M: "m", sdl.SCANCODE_N: "n", sdl.SCANCODE_O: "o",
+	sdl.SCANCODE_P: "p", sdl.SCANCODE_Q: "q", sdl.SCANCODE_R: "r", sdl.SCANCODE_S: "s", sdl.SCANCODE_T: "t",
+	sdl.SCANCODE_U: "u", sdl.SCANCODE_V: "v", sdl.SCANCODE_W: "w", sdl.SCANCODE_X: "x", sdl.SCANCODE_Y: "y",
+	sdl.SCANCODE_Z: "z", sdl.SCANCODE_MINUS: "minus", sdl.SCANCODE_EQUALS: "equals", sdl.SCANCODE_COMMA: "comma",
+	sdl.SCANCODE_PERIOD: "period", sdl.SCANCODE_SLASH: "slash", sdl.SCANCODE_SEMICOLON: "semicolon",
+	sdl.SCANCODE_APOSTROPHE: "apostrophe", sdl.SCANCODE_SPACE: "space", sdl.SCANCODE_RETURN: "enter",
+	sdl.SCANCODE_TAB: "tab", sdl.SCANCODE_BACKSPACE: "backspace",
+	sdl.SCANCODE_UP: "up", sdl.SCANCODE_DOWN: "down", sdl.SCANCODE_LEFT: "left", sdl.SCANCODE_RIGHT: "right",
+	sdl.SCANCODE_LSHIFT: "lshift", sdl.SCANCODE_RSHIFT: "rshift", sdl.SCANCODE_LCTRL: "lctrl",
+	sdl.SCANCODE_RCTRL: "rctrl", sdl.SCANCODE_LALT: "lalt", sdl.SCANCODE_RALT: "ralt",
+}
+
+// sdlKeys2 puts the CHIP-8X second keypad on the numeric keypad: the
+// digits are themselves, / * - + Enter and . are A to F.
+var sdlKeys2 = map[sdl.Keycode]uint8{
+	sdl.K_KP_0: 0x0, sdl.K_KP_1: 0x1, sdl.K_KP_2: 0x2, sdl.K_KP_3: 0x3,
+	sdl.K_KP_4: 0x4, sdl.K_KP_5: 0x5, sdl.K_KP_6: 0x6, sdl.K_KP_7: 0x7,
+	sdl.K_KP_8: 0x8, sdl.K_KP_9: 0x9, sdl.K_KP_DIVIDE: 0xA, sdl.K_KP_MULTIPLY: 0xB,
+	sdl.K_KP_MINUS: 0xC, sdl.K_KP_PLUS: 0xD, sdl.K_KP_ENTER: 0xE, sdl.K_KP_PERIOD: 0xF,
+}
+
+// sdlButtons gives the buttonNames in SDL's terms.
+var sdlButtons = map[sdl.GameControllerButton]string{
+	sdl.CONTROLLER_BUTTON_DPAD_UP: "up", sdl.CONTROLLER_BUTTON_DPAD_DOWN: "down",
+	sdl.CONTROLLER_BUTTON_DPAD_LEFT: "left", sdl.CONTROLLER_BUTTON_DPAD_RIGHT: "right",
+	sdl.CONTROLLER_BUTTON_A: "a", sdl.CONTROLLER_BUTTON_B: "b", sdl.CONTROLLER_BUTTON_X: "x", sdl.CONTROLLER_BUTTON_Y: "y",
+	sdl.CONTROLLER_BUTTON_BACK: "back", sdl.CONTROLLER_BUTTON_START: "start",
+	sdl.CONTROLLER_BUTTON_LEFTSHOULDER: "leftshoulder", sdl.CONTROLLER_BUTTON_RIGHTSHOULDER: "rightshoulder",
+	sdl.CONTROLLER_BUTTON_LEFTSTICK: "leftstick", sdl.CONTROLLER_BUTTON_RIGHTSTICK: "rightstick",
+}
+
+// sdlInput is the keyboard and game controllers as the chip's Input. The
+// event loop records which keys and buttons are held, by name, and the
+// keymap and buttons are looked up when the chip asks, so a -watch reload
+// that moves a key doesn't leave the old one stuck down.
+type sdlInput struct {
+	opts    *frontendOpts
+	keys    map[string]bool // keyNames held
+	buttons map[string]bool // buttonNames held
+	pad2    heldKeys        // the numeric keypad, as CHIP-8X's second keypad
+}
+
+func (in *sdlInput) Pressed(k uint8) bool {
+	for name := range in.keys {
+		if key, ok := in.opts.keys[name]; ok && key == k {
+			return true
+		}
+	}
+	for name := range in.buttons {
+		if key, ok := in.opts.buttons[name]; ok && key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitKey waits for the event loop, running meanwhile, to see a key go down.
+func (in *sdlInput) WaitKey() uint8 {
+	return waitKey(in)
+}
+
+// heldKeys is a keypad as an Input, kept up to date from key events.
+type heldKeys [16]bool
+
+func (h *heldKeys) Pressed(k uint8) bool {
+	return h[k&0xF]
+}
+
+func (h *heldKeys) WaitKey() uint8 {
+	return waitKey(h)
+}
+
+// set records the key or button name going down or up.
+func (in *sdlInput) set(held map[string]bool, name string, down bool) {
+	if down {
+		held[name] = true
+	} else {
+		delete(held, name)
+	}
+}
+
+// sdlFrontend draws into an SDL window surface.
+type sdlFrontend struct {
+	opts    *frontendOpts
+	window  *sdl.Window
+	surface *sdl.Surface
+
+	controllers map[sdl.JoystickID]*sdl.GameController // open game controllers, by instance
+	in          *sdlInput
+
+	running bool
+	paused  bool
+	asleep  bool // paused after a system suspend until a key is pressed
+}
+
+func openSDL(opts *frontendOpts) (Frontend, error) {
+	// Set up window and canvas
+	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
+		return nil, err
+	}
+
+	window, err := sdl.CreateWindow("hapax8", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
+		displayWidth*sdlScale+2*soundBorder, displayHeight*sdlScale+2*soundBorder, sdl.WINDOW_SHOWN)
+	if err != nil {
+		sdl.Quit()
+		return nil, err
+	}
+
+	surface, err := window.GetSurface()
+	if err != nil {
+		window.Destroy()
+		sdl.Quit()
+		return nil, err
+	}
+	surface.FillRect(nil, 0)
+	return newSDLFrontend(opts, window, surface), nil
+}
+
+func newSDLFrontend(opts *frontendOpts, window *sdl.Window, surface *sdl.Surface) *sdlFrontend {
+	return &sdlFrontend{
+		opts: opts, window: window, surface: surface,
+		controllers: map[sdl.JoystickID]*sdl.GameController{},
+		in:          &sdlInput{opts: opts, keys: map[string]bool{}, buttons: map[string]bool{}},
+	}
+}
+
+// PickROM shows the native file chooser, or explains how to pass -file in a
+// message box when there isn't one.
+func (f *sdlFrontend) PickROM() (string, error) {
+	path, err := nativeFileDialog("Open CHIP-8 ROM")
+	if err != nil {
+		sdl.ShowSimpleMessageBox(sdl.MESSAGEBOX_ERROR, "hapax8",
+			"No ROM given: "+err.Error()+".\nRun hapax8 -file path/to/rom.ch8", f.window)
+	}
+	return path, err
+}
+
+// Run executes the chip until the window is closed.
+func (f *sdlFrontend) Run(chip *Chip8) error {
+	defer sdl.Quit()
+	defer f.window.Destroy()
+	defer func() {
+		for _, c := range f.controllers {
+			c.Close()
+		}
+	}()
+
+	opts := &f.opts.display // a pointer, so -watch's reloads show
+	chip.SetInput(f.opts.input(f.in))
+	chip.SetInput2(&f.in.pad2)
+	defer chip.SetInput(nil)
+	defer chip.SetInput2(nil)
+	f.running = true
+	faulted := false
+	frames := time.NewTicker(frameTime)
+	defer frames.Stop()
+	for f.running {
+		f.opts.pollHandoff(chip)
+		f.opts.pollWatch(chip, time.Now())
+		if f.opts.resumed(time.Now()) {
+			clear(f.in.keys)
+			clear(f.in.buttons)
+			f.in.pad2 = heldKeys{}
+			f.asleep = true
+			f.window.SetTitle(asleepTitle)
+			f.opts.osd.show(asleepText, 0, time.Now())
+		}
+		if f.paused || f.asleep {
+			// Redrawn so the OSD can come and go while nothing runs.
+			chip.drawMemory(f.surface, f.window, *opts, false, f.opts.recolor(), &f.opts.osd)
+			f.window.UpdateSurface()
+			sdl.Delay(50)
+		} else {
+			res, err := chip.RunFrame()
+			reportFault(err, &faulted)
+			t := chip.prof.start()
+			chip.drawMemory(f.surface, f.window, *opts, res.Sound, f.opts.recolor(), &f.opts.osd)
+			t = chip.prof.lap(stageDraw, t)
+			f.window.UpdateSurface()
+			chip.prof.lap(stagePresent, t)
+			chip.prof.endFrame()
+			<-frames.C
+		}
+		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+			f.handle(chip, event)
+		}
+	}
+	return nil
+}
+
+// wake ends the pause after a suspend, on the first key or button pressed.
+func (f *sdlFrontend) wake() {
+	f.asleep = false
+	f.window.SetTitle("hapax8")
+	f.opts.osd.hide()
+}
+
+// handle acts on one event from SDL's queue. SDL hands events over as
+// values, not pointers, so the cases must be too.
+func (f *sdlFrontend) handle(chip *Chip8, event sdl.Event) {
+	opts := &f.opts.display
+	in := f.in
+	switch e := event.(type) {
+	case sdl.QuitEvent:
+		f.running = false
+	case sdl.KeyboardEvent:
+		if f.asleep && e.Type == sdl.KEYDOWN {
+			f.wake()
+			break
+		}
+		if name, ok := sdlKeys[e.Keysym.Scancode]; ok {
+			in.set(in.keys, name, e.Type == sdl.KEYDOWN)
+			break
+		}
+		if k, ok := sdlKeys2[e.Keysym.Sym]; ok {
+			in.pad2[k] = e.Type == sdl.KEYDOWN
+			break
+		}
+		if e.Type != sdl.KEYDOWN || e.Repeat != 0 {
+			break
+		}
+		switch e.Keysym.Sym {
+		case sdl.K_F1:
+			opts.invert = !opts.invert
+			f.opts.toggled("invert", opts.invert)
+		case sdl.K_F2:
+			opts.grid = !opts.grid
+			f.opts.toggled("grid", opts.grid)
+		case sdl.K_F3:
+			opts.flash = !opts.flash
+			f.opts.toggled("sound flash", opts.flash)
+		case sdl.K_F5:
+			f.opts.restart(chip)
+		}
+	case sdl.ControllerDeviceEvent:
+		// SDL sends an add for each controller already plugged in at
+		// startup too.
+		switch e.Type {
+		case sdl.CONTROLLERDEVICEADDED:
+			if c := sdl.GameControllerOpen(int(e.Which)); c != nil {
+				f.controllers[c.Joystick().InstanceID()] = c
+			}
+		case sdl.CONTROLLERDEVICEREMOVED:
+			if c, ok := f.controllers[e.Which]; ok {
+				c.Close()
+				delete(f.controllers, e.Which)
+			}
+		}
+	case sdl.ControllerButtonEvent:
+		if f.asleep && e.Type == sdl.CONTROLLERBUTTONDOWN {
+			f.wake()
+			break
+		}
+		if name, ok := sdlButtons[sdl.GameControllerButton(e.Button)]; ok {
+			in.set(in.buttons, name, e.Type == sdl.CONTROLLERBUTTONDOWN)
+		}
+	case sdl.WindowEvent:
+		if !f.opts.pauseUnfocused {
+			break
+		}
+		switch e.Event {
+		case sdl.WINDOWEVENT_FOCUS_LOST:
+			f.paused = true
+		case sdl.WINDOWEVENT_FOCUS_GAINED:
+			f.paused = false
+		}
+	}
+}
+
+var (
+	offColor   = sdl.Color{R: 0, G: 0, B: 0, A: 0}
+	gridColor  = sdl.Color{R: 80, G: 80, B: 80, A: 255}
+	soundColor = sdl.Color{R: 255, G: 176, B: 0, A: 255}
+)
+
+const (
+	sdlScale    = 10 // window pixels per display pixel
+	soundBorder = 8  // width of the sound flash border around the display
+)
+
+// drawMemory draws the display with whatever the OSD has up over it.
+func (c *Chip8) drawMemory(surface *sdl.Surface, window *sdl.Window, opts displayOpts, sounding bool, recolor func(color.Color) color.Color, o *osd) {
+	// The window stays the same size; hi-res pixels are half as big.
+	w, h := c.DisplaySize()
+	mask := o.render(w, h, time.Now())
+	xs, ys := pixelEdges(w, h, displayWidth*sdlScale, displayHeight*sdlScale)
+	if xs[0] > 0 {
+		// A MegaChip display is narrower than the window; blank either side.
+		black := sdl.MapRGBA(surface.Format, 0, 0, 0, 0)
+		surface.FillRect(&sdl.Rect{X: soundBorder, Y: soundBorder, W: int32(xs[0]), H: int32(ys[h])}, black)
+		surface.FillRect(&sdl.Rect{X: int32(soundBorder + xs[w]), Y: soundBorder, W: int32(displayWidth*sdlScale - xs[w]), H: int32(ys[h])}, black)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			rect := sdl.Rect{X: int32(soundBorder + xs[x]), Y: int32(soundBorder + ys[y]), W: int32(xs[x+1] - xs[x]), H: int32(ys[y+1] - ys[y])}
+			lit, unlit := c.Colors(x, y)
+			on, covered := osdCell(mask, w, x, y)
+			if covered {
+				lit, unlit = color.White, color.Black
+			} else {
+				on = c.Pixel(x, y)
+			}
+			if recolor != nil {
+				lit, unlit = recolor(lit), recolor(unlit)
+			}
+			fillPixel(surface, rect, on, sdlColor(lit), sdlColor(unlit), opts)
+		}
+	}
+	drawSoundBorder(surface, window, opts.flash && sounding)
+}
+
+// drawSoundBorder paints, or clears, a border around the window so sound
+// cues can be seen as well as heard.
+func drawSoundBorder(surface *sdl.Surface, window *sdl.Window, on bool) {
+	color := offColor
+	if on {
+		color = soundColor
+	}
+	pixel := sdl.MapRGBA(surface.Format, color.R, color.G, color.B, color.A)
+	w, h := window.GetSize()
+	for _, r := range []sdl.Rect{
+		{X: 0, Y: 0, W: w, H: soundBorder},
+		{X: 0, Y: h - soundBorder, W: w, H: soundBorder},
+		{X: 0, Y: 0, W: soundBorder, H: h},
+		{X: w - soundBorder, Y: 0, W: soundBorder, H: h},
+	} {
+		surface.FillRect(&r, pixel)
+	}
+}
+
+// sdlColor converts a color from the chip to SDL's terms.
+func sdlColor(c color.Color) sdl.Color {
+	r, g, b, a := c.RGBA()
+	return sdl.Color{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+}
+
+// fillPixel paints one scaled pixel in its lit or unlit color, applying the
+// inversion and grid options. Pixels too small to outline get no grid.
+func fillPixel(surface *sdl.Surface, rect sdl.Rect, on bool, lit, unlit sdl.Color, opts displayOpts) {
+	if opts.invert {
+		on = !on
+	}
+	color := unlit
+	if on {
+		color = lit
+	}
+	if opts.grid && rect.W > 2 && rect.H > 2 {
+		edge := sdl.MapRGBA(surface.Format, gridColor.R, gridColor.G, gridColor.B, gridColor.A)
+		surface.FillRect(&rect, edge)
+		rect = sdl.Rect{X: rect.X + 1, Y: rect.Y + 1, W: rect.W - 2, H: rect.H - 2}
+	}
+	pixel := sdl.MapRGBA(surface.Format, color.R, color.G, color.B, color.A)
+	surface.FillRect(&rect, pixel)
+}
+
+func (c *Chip8) drawLetter(surface *sdl.Surface, window *sdl.Window, offset, x, y int) {
+	for i := 0; i < 5; i++ {
+		data := bits.Reverse8(c.memory[offset+i])
+		for j := 0; j < 8; j++ {
+			pixelVal := (data & (1 << j)) >> j
+			rect := sdl.Rect{X: int32((j * 10) + x), Y: int32((i * 10) + y), W: 10, H: 10}
+			color := sdl.Color{}
+			if pixelVal == 1 {
+				color = sdl.Color{R: 255, G: 255, B: 255, A: 255}
+			} else {
+				color = sdl.Color{R: 0, G: 0, B: 0, A: 0}
+			}
+
+			pixel := sdl.MapRGBA(surface.Format, color.R, color.G, color.B, color.A)
+
+			surface.FillRect(&rect, pixel)
+		}
+	}
+	window.UpdateSurface()
+}
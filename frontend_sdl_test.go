@@ -0,0 +1,47 @@
+//go:build sdl || (cgo && !tty && !ebiten && !wasm)
+
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// TestSDLEvents tests that the events SDL queues, which are values rather
+// than pointers, reach the keypad, the toggles and the pause
+func TestSDLEvents(t *testing.T) {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.Init()
+	chip.LoadROM([]byte{0x61, 0x06, 0xE1, 0x9E, 0x12, 0x02, 0x12, 0x06}) // loop until key 6 is down
+	opts := &frontendOpts{keys: keypadKeys, pauseUnfocused: true}
+	f := newSDLFrontend(opts, nil, nil)
+	f.running = true
+	chip.SetInput(f.in)
+
+	f.handle(chip, sdl.KeyboardEvent{Type: sdl.KEYDOWN, Keysym: sdl.Keysym{Scancode: sdl.SCANCODE_E}})
+	chip.RunFrame()
+	if chip.pc != 0x206 {
+		t.Errorf("E down: got PC %03X, expected EX9E to see key 6 and reach 206", chip.pc)
+	}
+	f.handle(chip, sdl.KeyboardEvent{Type: sdl.KEYUP, Keysym: sdl.Keysym{Scancode: sdl.SCANCODE_E}})
+	chip.RunFrame()
+	if chip.pad()[0x6] {
+		t.Errorf("E up: key 6 still down")
+	}
+
+	f.handle(chip, sdl.KeyboardEvent{Type: sdl.KEYDOWN, Keysym: sdl.Keysym{Sym: sdl.K_F1}})
+	if !opts.display.invert {
+		t.Errorf("F1 didn't invert the display")
+	}
+	f.handle(chip, sdl.WindowEvent{Type: sdl.WINDOWEVENT, Event: sdl.WINDOWEVENT_FOCUS_LOST})
+	if !f.paused {
+		t.Errorf("losing focus didn't pause")
+	}
+	f.handle(chip, sdl.QuitEvent{Type: sdl.QUIT})
+	if f.running {
+		t.Errorf("QuitEvent didn't stop the loop")
+	}
+}
@@ -0,0 +1,120 @@
+//go:build tty || !(sdl || ebiten || wasm)
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+func init() {
+	registerFrontend("tty", 10, openTTY)
+}
+
+// ttyBlocks are the characters for a pair of stacked pixels, indexed by
+// top<<1 | bottom. Terminal cells are about twice as tall as they are wide,
+// so this keeps pixels square.
+var ttyBlocks = [4]string{" ", "▄", "▀", "█"}
+
+// ttyFrontend draws the display into an ANSI terminal, one character per
+// two pixels stacked.
+// It needs no cgo, so it is what a minimal build runs with.
+type ttyFrontend struct {
+	opts  *frontendOpts
+	out   *bufio.Writer
+	width int // display width last drawn, to notice SCHIP mode switches
+}
+
+func openTTY(opts *frontendOpts) (Frontend, error) {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return nil, errors.New("stdout is not a terminal")
+	}
+	return &ttyFrontend{opts: opts, out: bufio.NewWriter(os.Stdout)}, nil
+}
+
+// Run executes the chip a frame at a time until interrupted.
+func (f *ttyFrontend) Run(chip *Chip8) error {
+	chip.trace = io.Discard // stdout is the screen
+	f.out.WriteString("\x1b[2J\x1b[?25l")
+	defer os.Stdout.WriteString("\x1b[?25h\n")
+
+	chip.SetInput(f.opts.input(nil)) // the extra keypads, if any
+	defer chip.SetInput(nil)
+	frames := time.NewTicker(frameTime)
+	defer frames.Stop()
+	for now := range frames.C {
+		// There's no keyboard input to wait for here, so after a suspend
+		// the keys are released and the program carries on.
+		if f.opts.resumed(now) {
+			f.opts.osd.show("resumed after sleep", osdNotice, now)
+		}
+		f.opts.pollHandoff(chip)
+		if f.opts.pollWatch(chip, now) {
+			f.opts.restart(chip) // there's no F5 to wait for here
+		}
+		res, fault := chip.RunFrame()
+		t := chip.prof.start()
+		f.draw(chip, res.Sound)
+		t = chip.prof.lap(stageDraw, t)
+		if err := f.out.Flush(); err != nil {
+			return err
+		}
+		chip.prof.lap(stagePresent, t)
+		chip.prof.endFrame()
+		// Nobody may be watching, so a stopped program ends the run, its
+		// last frame left on the screen.
+		if fault != nil {
+			return fmt.Errorf("fault: %w", fault)
+		}
+		if res.Halted {
+			return nil
+		}
+	}
+	return nil
+}
+
+// draw renders the display into f.out; flushing it is left to the caller.
+func (f *ttyFrontend) draw(c *Chip8, sounding bool) {
+	w, h := c.DisplaySize()
+	if w != f.width {
+		f.out.WriteString("\x1b[2J") // don't leave the wider display's right half behind
+		f.width = w
+	}
+	f.out.WriteString("\x1b[H")
+	inv := f.opts.display.invert
+	mask := f.opts.osd.render(w, h, time.Now())
+	lit := func(x, y int) bool {
+		on, covered := osdCell(mask, w, x, y)
+		if !covered {
+			on = c.Pixel(x, y)
+		}
+		return on != inv
+	}
+	for y := 0; y < h; y += 2 {
+		for x := 0; x < w; x++ {
+			i := 0
+			if lit(x, y) {
+				i |= 2
+			}
+			if lit(x, y+1) {
+				i |= 1
+			}
+			f.out.WriteString(ttyBlocks[i])
+		}
+		f.out.WriteString("\r\n")
+	}
+	// The status line stands in for the border other frontends flash.
+	if f.opts.display.flash && sounding {
+		f.out.WriteString("\x1b[7m SOUND \x1b[0m\r\n")
+	} else {
+		f.out.WriteString("       \r\n")
+	}
+}
@@ -0,0 +1,113 @@
+//go:build linux && gpio
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gpioSettle is how long a driven row is given before its columns are read.
+const gpioSettle = 10 * time.Microsecond
+
+var (
+	gpioRows = flag.String("gpio-rows", "", "GPIO pins wired to the keypad's four rows, e.g. 5,6,13,19")
+	gpioCols = flag.String("gpio-cols", "", "GPIO pins wired to the keypad's four columns, which need pull-up resistors")
+)
+
+func init() {
+	keypadOpeners = append(keypadOpeners, openGPIOPad)
+}
+
+// gpioPad scans a 4x4 matrix keypad through the sysfs GPIO interface, as
+// on a Raspberry Pi. Each row is driven low in turn; a key that's down
+// pulls its column low with it.
+type gpioPad struct {
+	rows [4]*os.File // value files of the row pins, set to output
+	cols [4]*os.File // value files of the column pins, set to input
+}
+
+func openGPIOPad() (keypad, error) {
+	if *gpioRows == "" && *gpioCols == "" {
+		return nil, nil
+	}
+	rows, err := parsePins(*gpioRows)
+	if err != nil {
+		return nil, fmt.Errorf("-gpio-rows: %v", err)
+	}
+	cols, err := parsePins(*gpioCols)
+	if err != nil {
+		return nil, fmt.Errorf("-gpio-cols: %v", err)
+	}
+	p := new(gpioPad)
+	for i := range rows {
+		// Rows idle high so only the one being scanned pulls a column down.
+		if p.rows[i], err = openPin(rows[i], "high"); err != nil {
+			return nil, err
+		}
+		if p.cols[i], err = openPin(cols[i], "in"); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// parsePins parses a comma-separated list of four pin numbers.
+func parsePins(s string) ([4]int, error) {
+	var pins [4]int
+	f := strings.Split(s, ",")
+	if len(f) != len(pins) {
+		return pins, fmt.Errorf("want 4 pins, got %q", s)
+	}
+	for i, p := range f {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return pins, fmt.Errorf("bad pin %q", p)
+		}
+		pins[i] = n
+	}
+	return pins, nil
+}
+
+// openPin exports a pin, sets its direction ("in", or "high" for an output
+// starting high) and opens its value file.
+func openPin(pin int, direction string) (*os.File, error) {
+	dir := fmt.Sprintf("/sys/class/gpio/gpio%d", pin)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.WriteFile("/sys/class/gpio/export", []byte(strconv.Itoa(pin)), 0); err != nil {
+			return nil, fmt.Errorf("gpio %d: %v", pin, err)
+		}
+	}
+	if err := os.WriteFile(dir+"/direction", []byte(direction), 0); err != nil {
+		return nil, fmt.Errorf("gpio %d: %v", pin, err)
+	}
+	return os.OpenFile(dir+"/value", os.O_RDWR, 0)
+}
+
+// Keys implements keypad.
+func (p *gpioPad) Keys() (uint16, error) {
+	var mask uint16
+	for r, row := range p.rows {
+		if _, err := row.WriteAt([]byte("0"), 0); err != nil {
+			return 0, err
+		}
+		time.Sleep(gpioSettle)
+		for c, col := range p.cols {
+			var b [1]byte
+			if _, err := col.ReadAt(b[:], 0); err != nil {
+				return 0, err
+			}
+			if b[0] == '0' {
+				mask |= 1 << padRows[r][c]
+			}
+		}
+		if _, err := row.WriteAt([]byte("1"), 0); err != nil {
+			return 0, err
+		}
+	}
+	return mask, nil
+}
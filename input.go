@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// scheduledInput is key state waiting for the next frame boundary, so
+// replays and scripts see exactly the same input on every run.
+type scheduledInput struct {
+	mask    uint16 // whole-pad state from SetKeys
+	pending bool   // mask is waiting to be applied
+	holds   [16]int
+	held    [16]bool // the key is down because of PressKey
+}
+
+// keyWait follows an FX0A through the frames it waits. Only a key that goes
+// down after the wait starts counts, so one still held from the last menu
+// doesn't answer the next.
+type keyWait struct {
+	active bool     // the FX0A at the PC has started waiting
+	before [16]bool // the keypad when it last looked
+	key    int8     // the key pressed, waiting to be released; -1 until one is
+}
+
+// SetKeys sets the whole keypad at the start of the next frame, bit k of
+// mask holding key k down. It cancels any PressKey still in progress.
+func (c *Chip8) SetKeys(mask uint16) {
+	c.input = scheduledInput{mask: mask, pending: true}
+}
+
+// PressKey holds key k down for the next frames frames, starting with the
+// next one, and releases it after.
+func (c *Chip8) PressKey(k uint8, frames int) {
+	c.input.holds[k&0xF] = frames
+}
+
+// Input is a keypad the chip asks about, once a frame, rather than being
+// told of each change. Every frontend and extra keypad hands its keys to
+// the chip as one; SetKey remains for programs that drive the chip by hand.
+// A key is down if either says it is, and SetKeys and PressKey replays go
+// on top.
+//
+// WaitKey blocks until a key goes down and returns it. The frame loop never
+// calls it: FX0A waits across frames, asking Pressed each time, so the
+// timers and display carry on meanwhile. It is for callers that step the
+// chip an instruction at a time, as the REPL does.
+type Input interface {
+	Pressed(key uint8) bool
+	WaitKey() uint8
+}
+
+// frameStarter is an Input that needs to know when a frame starts, as a
+// script does. The chip tells it just before asking about the keys.
+type frameStarter interface {
+	startFrame()
+}
+
+// SetInput has the chip ask in about the keypad from the next frame on; nil
+// stops it.
+func (c *Chip8) SetInput(in Input) {
+	c.source = in
+	c.polled = [16]bool{}
+}
+
+// SetInput2 is SetInput for the second keypad, which only CHIP-8X programs
+// read.
+func (c *Chip8) SetInput2(in Input) {
+	c.source2 = in
+	c.polled2 = [16]bool{}
+}
+
+// pad is the keypad as the program sees it: what SetKey and the schedule
+// say, and what the Input said at the start of the frame.
+func (c *Chip8) pad() [16]bool {
+	return eitherKeys(c.keys, c.polled)
+}
+
+// pad2 is pad for the second keypad.
+func (c *Chip8) pad2() [16]bool {
+	return eitherKeys(c.keys2, c.polled2)
+}
+
+func eitherKeys(a, b [16]bool) [16]bool {
+	for k, down := range b {
+		a[k] = a[k] || down
+	}
+	return a
+}
+
+// poll asks in about every key, as the frame it's for starts.
+func poll(in Input, keys *[16]bool) {
+	if in == nil {
+		return
+	}
+	if f, ok := in.(frameStarter); ok {
+		f.startFrame()
+	}
+	for k := range keys {
+		keys[k] = in.Pressed(uint8(k))
+	}
+}
+
+// waitKey is WaitKey for a live keypad: it asks in about the keys once a
+// frame until one goes down that wasn't down when it started.
+func waitKey(in Input) uint8 {
+	var before [16]bool
+	poll(in, &before)
+	for {
+		time.Sleep(time.Second / 60)
+		var keys [16]bool
+		poll(in, &keys)
+		for k, down := range keys {
+			if down && !before[k] {
+				return uint8(k)
+			}
+		}
+		before = keys
+	}
+}
+
+// inputs are several keypads as one Input: a key is down if it's down on
+// any of them.
+type inputs []Input
+
+func (in inputs) Pressed(k uint8) bool {
+	for _, i := range in {
+		if i.Pressed(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (in inputs) WaitKey() uint8 {
+	return waitKey(in)
+}
+
+func (in inputs) startFrame() {
+	for _, i := range in {
+		if f, ok := i.(frameStarter); ok {
+			f.startFrame()
+		}
+	}
+}
+
+// scriptInput is an Input that plays keys back a frame at a time: step i is
+// the keypad in frame i, counting from 0, bit k holding key k down. Once
+// the steps run out the last one stays. Tests use it to press the same
+// keys at the same frames on every run.
+type scriptInput struct {
+	steps []uint16
+	frame int // frames started
+}
+
+func (s *scriptInput) startFrame() {
+	s.frame++
+}
+
+func (s *scriptInput) Pressed(k uint8) bool {
+	return s.step()&(1<<k) != 0
+}
+
+// WaitKey plays the script forward to the next key to go down. A script
+// that runs out first has none to give, so it gives the lowest key its last
+// step holds, or 0.
+func (s *scriptInput) WaitKey() uint8 {
+	for {
+		before := s.step()
+		if s.frame >= len(s.steps) {
+			return lowestKey(before)
+		}
+		s.frame++
+		if pressed := s.step() &^ before; pressed != 0 {
+			return lowestKey(pressed)
+		}
+	}
+}
+
+// step is the keypad in the frame started last.
+func (s *scriptInput) step() uint16 {
+	if s.frame == 0 || len(s.steps) == 0 {
+		return 0
+	}
+	return s.steps[min(s.frame, len(s.steps))-1]
+}
+
+func lowestKey(mask uint16) uint8 {
+	return uint8(bits.TrailingZeros16(mask)) & 0xF
+}
+
+// applyInput asks the Inputs about the keypads and makes the scheduled key
+// changes for the frame about to run.
+func (c *Chip8) applyInput() {
+	poll(c.source, &c.polled)
+	poll(c.source2, &c.polled2)
+	in := &c.input
+	if in.pending {
+		for k := range c.keys {
+			c.keys[k] = in.mask&(1<<k) != 0
+		}
+		in.pending = false
+	}
+	for k, n := range in.holds {
+		switch {
+		case n > 0:
+			c.keys[k] = true
+			in.held[k] = true
+			in.holds[k]--
+		case in.held[k]:
+			c.keys[k] = false
+			in.held[k] = false
+		}
+	}
+}
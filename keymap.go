@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// keyNames are the keyboard keys a keymap can put keypad keys on. They are
+// named for where they sit on a US QWERTY keyboard: frontends read keys by
+// position, so on other layouts a name is the key in that place, whatever
+// its label, unless -keyboard says which labels to go by. The numeric
+// keypad is left out, as it's CHIP-8X's second pad.
+var keyNames = []string{
+	"1", "2", "3", "4", "5", "6", "7", "8", "9", "0",
+	"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m",
+	"n", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z",
+	"minus", "equals", "comma", "period", "slash", "semicolon", "apostrophe",
+	"space", "enter", "tab", "backspace", "up", "down", "left", "right",
+	"lshift", "rshift", "lctrl", "rctrl", "lalt", "ralt",
+}
+
+// keymap says which hex keypad key each keyboard key or controller button,
+// by name, stands for.
+type keymap map[string]uint8
+
+// keypadKeys is the default keymap, the 1-V block of the keyboard standing
+// in for the COSMAC VIP's 4x4 pad:
+//
+//	1 2 3 4    1 2 3 C
+//	Q W E R    4 5 6 D
+//	A S D F    7 8 9 E
+//	Z X C V    A 0 B F
+var keypadKeys = keymap{
+	"1": 0x1, "2": 0x2, "3": 0x3, "4": 0xC,
+	"q": 0x4, "w": 0x5, "e": 0x6, "r": 0xD,
+	"a": 0x7, "s": 0x8, "d": 0x9, "f": 0xE,
+	"z": 0xA, "x": 0x0, "c": 0xB, "v": 0xF,
+}
+
+// padRows is the VIP keypad's keys as they are laid out, row by row. A 4x4
+// matrix keypad on GPIO is read as laid out the same way.
+var padRows = [4][4]uint8{
+	{0x1, 0x2, 0x3, 0xC},
+	{0x4, 0x5, 0x6, 0xD},
+	{0x7, 0x8, 0x9, 0xE},
+	{0xA, 0x0, 0xB, 0xF},
+}
+
+// keyRows are the keys, by their US names, whose labels differ between the
+// layouts in keyboards.
+const keyRows = "minus equals q w e r t y u i o p a s d f g h j k l semicolon apostrophe z x c v b n m comma period slash"
+
+// keyboards are the layouts -keyboard knows, each the labels on the keys
+// keyRows lists, in the same order. "." is a key whose label has no name
+// here, such as AZERTY's ù; the keys outside keyRows are labelled alike on
+// all of them, digits included.
+var keyboards = map[string]string{
+	"qwerty": keyRows,
+	"azerty": ". equals a z e r t y u i o p q s d f g h j k l m . w x c v b n comma semicolon . .",
+	"qwertz": ". . q w e r t z u i o p a s d f g h j k l . . y x c v b n m comma period minus",
+	"dvorak": ". . apostrophe comma period p y f g c r l a o e u i d h t n s minus semicolon q j k x b m w v z",
+}
+
+// keyboardNames lists the -keyboard values.
+var keyboardNames = []string{"qwerty", "azerty", "qwertz", "dvorak"}
+
+// keyLabels returns what each key is labelled on keyboard, by its US name.
+// Keys without a named label are left out.
+func keyLabels(keyboard string) (map[string]string, error) {
+	labels, ok := keyboards[keyboard]
+	if !ok {
+		return nil, fmt.Errorf("unknown keyboard %q: use %s", keyboard, strings.Join(keyboardNames, ", "))
+	}
+	m := map[string]string{}
+	for _, name := range keyNames {
+		m[name] = name
+	}
+	for i, name := range strings.Fields(keyRows) {
+		m[name] = strings.Fields(labels)[i]
+		if m[name] == "." {
+			delete(m, name)
+		}
+	}
+	return m, nil
+}
+
+// parseKeymap reads a keymap given as entries such as "5=up", separated
+// by commas, spaces or lines, with # starting a comment. Keys are named by
+// their labels on keyboard. Each hex key that appears is on exactly the
+// keys listed for it, none for "5=", and the rest keep their default keys
+// unless those are taken. The defaults are by position, the same on every
+// keyboard.
+func parseKeymap(spec, keyboard string) (keymap, error) {
+	labels, err := keyLabels(keyboard)
+	if err != nil {
+		return nil, err
+	}
+	keyAt := map[string]string{} // labels to US names
+	var known []string
+	for _, name := range keyNames {
+		if l, ok := labels[name]; ok {
+			keyAt[l] = name
+			known = append(known, l)
+		}
+	}
+	return parseBindings(spec, keyboard+" keymap", keyAt, known, keypadKeys)
+}
+
+// parseBindings reads parseKeymap's entries for what, a keymap or a set of
+// game controller buttons. names maps the names an entry may use to the key
+// or button each stands for, and known lists them for error messages.
+func parseBindings(spec, what string, names map[string]string, known []string, defaults keymap) (keymap, error) {
+	given := keymap{}
+	cleared := map[uint8]bool{}
+	for _, line := range strings.Split(spec, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		for _, entry := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' }) {
+			hex, label, ok := strings.Cut(entry, "=")
+			k, err := strconv.ParseUint(hex, 16, 4)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("%s entry %q isn't a hex key = a key or button, like 5=up", what, entry)
+			}
+			cleared[uint8(k)] = true
+			if label == "" {
+				continue
+			}
+			label = strings.ToLower(label)
+			name, ok := names[label]
+			if !ok {
+				return nil, fmt.Errorf("%s entry %q: no %q here; there are %s", what, entry, label, strings.Join(known, " "))
+			}
+			if old, ok := given[name]; ok && old != uint8(k) {
+				return nil, fmt.Errorf("%s puts %s on both %X and %X", what, label, old, k)
+			}
+			given[name] = uint8(k)
+		}
+	}
+	keys := keymap{}
+	for name, k := range defaults {
+		if _, taken := given[name]; !taken && !cleared[k] {
+			keys[name] = k
+		}
+	}
+	for name, k := range given {
+		keys[name] = k
+	}
+	return keys, nil
+}
+
+// format writes the keymap in parseKeymap's form for keyboard, a row of
+// the keypad a line, so it can be saved and edited.
+func (m keymap) format(keyboard string) string {
+	labels, _ := keyLabels(keyboard) // checked by parseKeymap
+	var b strings.Builder
+	for _, row := range padRows {
+		var entries []string
+		for _, k := range row {
+			n := len(entries)
+			for _, name := range keyNames {
+				if key, ok := m[name]; ok && key == k {
+					entries = append(entries, fmt.Sprintf("%X=%s", k, labels[name]))
+				}
+			}
+			if len(entries) == n {
+				entries = append(entries, fmt.Sprintf("%X=", k))
+			}
+		}
+		b.WriteString(strings.Join(entries, " ") + "\n")
+	}
+	return b.String()
+}
+
+// keymapFlag is what -keymap and -buttons set: the value is a keymap, or if
+// it has no = in it, a file holding one. The keymap itself is kept, so a
+// bundle made with the flag carries it rather than the file name. It is
+// checked once -keyboard is known too.
+func keymapFlag(dst *string) func(string) error {
+	return func(v string) error {
+		if v != "" && !strings.Contains(v, "=") {
+			b, err := os.ReadFile(v)
+			if err != nil {
+				return err
+			}
+			v = string(b)
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// buttonNames are the game controller buttons -buttons can put keypad keys
+// on, named as on the Xbox-style pad SDL maps every controller it knows to.
+var buttonNames = []string{
+	"up", "down", "left", "right", "a", "b", "x", "y",
+	"back", "start", "leftshoulder", "rightshoulder", "leftstick", "rightstick",
+}
+
+// padButtons are the default buttons: the D-pad on 5 7 8 9 and A and B on
+// 6 and 4, the keys Octo puts on W A S D, E and Q, which most games use.
+var padButtons = keymap{"up": 0x5, "left": 0x7, "down": 0x8, "right": 0x9, "a": 0x6, "b": 0x4}
+
+// parseButtons reads -buttons, which is written as a keymap is but names
+// controller buttons.
+func parseButtons(spec string) (keymap, error) {
+	names := map[string]string{}
+	for _, name := range buttonNames {
+		names[name] = name
+	}
+	return parseBindings(spec, "buttons", names, buttonNames, padButtons)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// lintIssue is one problem "hapax8 lint" found, at the address it was found.
+type lintIssue struct {
+	addr uint16
+	msg  string
+}
+
+// lintContext is an instruction as reached along one chain of calls: the
+// return addresses on the stack, two bytes each, make it a string so it
+// can key a map.
+type lintContext struct {
+	pc    uint16
+	stack string
+}
+
+// lintROM walks every path through a ROM loaded at start, as inferSymbols
+// does but following each subroutine's returns back to its callers. It
+// reports registers read where some path hasn't set them, returns without
+// a call, calls nested deeper than the stack, jumps out of the ROM, words
+// that aren't opcodes and bytes that are neither run nor loaded into I.
+// The quirks decide which registers the shifts and BNNN read.
+func lintROM(rom []uint8, start uint16, q Quirks) []lintIssue {
+	end := int(start) + len(rom)
+	inROM := func(addr uint16) bool { return int(addr) >= int(start) && int(addr)+1 < end }
+	code := make([]bool, len(rom))
+	loaded := map[uint16]bool{} // LOADI targets
+	indirect := uint16(0)       // a BNNN reached, if nonzero
+
+	var issues []lintIssue
+	seen := map[lintIssue]bool{}
+	report := func(addr uint16, format string, args ...any) {
+		is := lintIssue{addr, fmt.Sprintf(format, args...)}
+		if !seen[is] {
+			seen[is] = true
+			issues = append(issues, is)
+		}
+	}
+
+	// unset holds, for each context reached, the registers that may not
+	// have been written on some path to it.
+	unset := map[lintContext]uint16{}
+	var work []lintContext
+	flow := func(from uint16, to lintContext, mask uint16) {
+		switch {
+		case !inROM(to.pc) && int(to.pc) >= end && to.pc <= from+4:
+			report(from, "runs off the end of the ROM")
+			return
+		case !inROM(to.pc):
+			report(from, "jumps to %#x, outside the ROM", to.pc)
+			return
+		}
+		old, ok := unset[to]
+		if ok && old|mask == old {
+			return
+		}
+		unset[to] = old | mask
+		work = append(work, to)
+	}
+	flow(start, lintContext{pc: start}, 0xFFFF)
+
+	for len(work) > 0 {
+		at := work[len(work)-1]
+		work = work[:len(work)-1]
+		pc, mask := at.pc, unset[at]
+		op := uint16(rom[pc-start])<<8 | uint16(rom[pc-start+1])
+		size := uint16(2)
+		if op == 0xF000 {
+			size = 4 // XO-CHIP's long index load carries its address
+		}
+		for i := int(pc - start); i < int(pc-start+size) && i < len(rom); i++ {
+			code[i] = true
+		}
+		next := lintContext{pc + size, at.stack}
+
+		reads, writes := lintRegs(op, q)
+		for bad := reads & mask; bad != 0; bad &= bad - 1 {
+			report(pc, "reads v%X, which isn't set on every path here", bits.TrailingZeros16(bad))
+		}
+		mask &^= writes
+
+		switch {
+		case op == 0x0000 || op == 0x00FD: // the code has run into zeroed memory, or exits
+		case lookupOpcode(op) == nil:
+			report(pc, "%04X isn't an opcode", op)
+		case op == 0x00EE:
+			if at.stack == "" {
+				report(pc, "RET without a CALL")
+				break
+			}
+			n := len(at.stack) - 2
+			ret := uint16(at.stack[n])<<8 | uint16(at.stack[n+1])
+			flow(pc, lintContext{ret, at.stack[:n]}, mask)
+		case op&0xF000 == 0x1000:
+			flow(pc, lintContext{targetAddr(op), at.stack}, mask)
+		case op&0xF000 == 0x2000:
+			if len(at.stack)/2 == defaultStackDepth {
+				report(pc, "CALL can nest more than %d deep; does a subroutine jump back instead of returning?", defaultStackDepth)
+				break
+			}
+			ret := string([]byte{byte(next.pc >> 8), byte(next.pc)})
+			flow(pc, lintContext{targetAddr(op), at.stack + ret}, mask)
+		case op&0xF000 == 0xB000:
+			if indirect == 0 {
+				indirect = pc
+			}
+		case op&0xF000 == 0x3000, op&0xF000 == 0x4000,
+			op&0xF00F == 0x5000, op&0xF00F == 0x9000,
+			op&0xF0FF == 0xE09E, op&0xF0FF == 0xE0A1:
+			flow(pc, next, mask)
+			flow(pc, lintContext{next.pc + 2, at.stack}, mask)
+		default:
+			if op&0xF000 == 0xA000 {
+				loaded[targetAddr(op)] = true
+			}
+			if op == 0xF000 && inROM(pc+2) {
+				loaded[uint16(rom[pc-start+2])<<8|uint16(rom[pc-start+3])] = true
+			}
+			flow(pc, next, mask)
+		}
+	}
+
+	// Code reached only through BNNN can't be told from data.
+	if indirect != 0 {
+		report(indirect, "BNNN can't be followed, so unreachable code isn't reported")
+	} else {
+		for i := 0; i < len(rom); {
+			if code[i] {
+				i++
+				continue
+			}
+			j, used, n := i, false, 0
+			for ; j < len(rom) && !code[j]; j++ {
+				used = used || loaded[start+uint16(j)]
+				if rom[j] != 0 {
+					n = j + 1 - i
+				}
+			}
+			// Trailing zeroes are padding or buffers rather than lost code.
+			if !used && n > 0 {
+				report(start+uint16(i), "%d bytes never run and never loaded into I", n)
+			}
+			i = j
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].addr < issues[j].addr })
+	return issues
+}
+
+// lintRegs returns the registers op reads and the ones it is sure to
+// write, a bit each.
+func lintRegs(op uint16, q Quirks) (reads, writes uint16) {
+	x, y := uint16(1)<<(op>>8&0xF), uint16(1)<<(op>>4&0xF)
+	upTo := func(r uint16) uint16 { return r<<1 - 1 } // V0 to the register r
+	const vf = 1 << 0xF
+	switch op & 0xF000 {
+	case 0x3000, 0x4000:
+		return x, 0
+	case 0x5000:
+		lo, hi := min(x, y), max(x, y)
+		span := upTo(hi) &^ (lo - 1)
+		switch op & 0xF {
+		case 0x2:
+			return span, 0
+		case 0x3:
+			return 0, span
+		}
+		return x | y, 0
+	case 0x6000, 0xC000:
+		return 0, x
+	case 0x7000:
+		return x, x
+	case 0x8000:
+		switch op & 0xF {
+		case 0x0:
+			return y, x
+		case 0x1, 0x2, 0x3:
+			if q.LogicResetVF {
+				return x | y, x | vf
+			}
+			return x | y, x
+		case 0x6, 0xE:
+			if q.Shift {
+				return x, x | vf
+			}
+			return y, x | vf
+		}
+		return x | y, x | vf
+	case 0x9000:
+		return x | y, 0
+	case 0xB000:
+		if q.JumpOffset {
+			return x, 0
+		}
+		return 1, 0
+	case 0xD000:
+		return x | y, vf
+	case 0xE000:
+		return x, 0
+	case 0xF000:
+		switch op & 0xFF {
+		case 0x07, 0x0A:
+			return 0, x
+		case 0x1E:
+			if q.IndexOverflow {
+				return x, vf
+			}
+			return x, 0
+		case 0x15, 0x18, 0x29, 0x30, 0x33, 0x3A:
+			return x, 0
+		case 0x55, 0x75:
+			return upTo(x), 0
+		case 0x65, 0x85:
+			return 0, upTo(x)
+		}
+	}
+	return 0, 0
+}
+
+// runLint implements "hapax8 lint [flags] rom.ch8". The quirk flags and
+// -start-addr are taken into account.
+func runLint(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var set settings
+	set.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hapax8 lint [flags] rom.ch8")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("lint takes exactly one ROM")
+	}
+	rom, b, err := readROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		set = b.Settings
+		fs.Parse(args)
+	}
+	c := new(Chip8)
+	c.trace = io.Discard
+	c.Init()
+	if err := set.apply(c, new(frontendOpts)); err != nil {
+		return err
+	}
+	issues := lintROM(rom, c.progStart, c.quirks)
+	for _, is := range issues {
+		fmt.Fprintf(stdout, "%03X: %s\n", is.addr, is.msg)
+	}
+	if len(issues) > 0 {
+		return errors.New("lint found problems")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// diag is where warnings, faults and end-of-run reports go: stderr, plus
+// the -log-file if one is given. GUI users often have no terminal, so the
+// file is the only place they can find them afterwards.
+var diag io.Writer = os.Stderr
+
+// rotatingFile appends to a log file. Once it grows past max bytes it is
+// moved aside to path.1, replacing the previous one, and a fresh file is
+// started, so the log never takes more than about twice max.
+type rotatingFile struct {
+	path string
+	max  int64
+	f    *os.File
+	size int64
+}
+
+func openRotating(path string, max int64) (*rotatingFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rotatingFile{path: path, max: max, f: f, size: info.Size()}, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.size > 0 && r.size+int64(len(p)) > r.max {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	r.f, r.size = f, 0
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
@@ -3,386 +3,242 @@ package main
 import (
 	"flag"
 	"fmt"
-	"math/bits"
+	"io"
 	"os"
-
-	"github.com/veandco/go-sdl2/sdl"
+	"path/filepath"
+	"strings"
 )
 
-const progStart = 0x200
-const memSize = 4096
-const FONTSET_SIZE = 80
-const FONT_OFFSET = 0x50
-
-var fontSet = [FONTSET_SIZE]uint8{
-	0xF0, 0x90, 0x90, 0x90, 0xF0, // 0
-	0x20, 0x60, 0x20, 0x20, 0x70, // 1
-	0xF0, 0x10, 0xF0, 0x80, 0xF0, // 2
-	0xF0, 0x10, 0xF0, 0x10, 0xF0, // 3
-	0x90, 0x90, 0xF0, 0x10, 0x10, // 4
-	0xF0, 0x80, 0xF0, 0x10, 0xF0, // 5
-	0xF0, 0x80, 0xF0, 0x90, 0xF0, // 6
-	0xF0, 0x10, 0x20, 0x40, 0x40, // 7
-	0xF0, 0x90, 0xF0, 0x90, 0xF0, // 8
-	0xF0, 0x90, 0xF0, 0x10, 0xF0, // 9
-	0xF0, 0x90, 0xF0, 0x90, 0x90, // A
-	0xE0, 0x90, 0xE0, 0x90, 0xE0, // B
-	0xF0, 0x80, 0x80, 0x80, 0xF0, // C
-	0xE0, 0x90, 0x90, 0x90, 0xE0, // D
-	0xF0, 0x80, 0xF0, 0x80, 0xF0, // E
-	0xF0, 0x80, 0xF0, 0x80, 0x80, // F
-}
-
-// Chip8 is our emulated processor state
-type Chip8 struct {
-	inst       uint16
-	memory     []uint8
-	v          [16]uint8 // register block
-	index      uint16    // index reg
-	pc         uint16    // program counter
-	gfx        []uint8   // pixel array for graphics
-	delayTimer uint8
-	soundTimer uint8
-	stack      [16]uint16
-	sp         uint16
-}
-
-/*
-0x000-0x1FF - Chip 8 interpreter (contains font set in emu)
-0x050-0x0A0 - Used for the built in 4x5 pixel font set (0-F)
-0x200-0xFFF - Program ROM and work RAM
-*/
-
-// LoadProgram loads the program from a file into the Chip8's memory.
-func (c *Chip8) LoadProgram(prog string) {
-	progFile, err := os.Open(prog)
-	if err != nil {
-		panic(err)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "repl":
+			repl(os.Stdin, os.Stdout)
+			return
+		case "symbols":
+			if err := runSymbols(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "trace":
+			if err := runTrace(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "help":
+			if err := runHelp(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			return
+		case "minimize":
+			if err := runMinimize(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			return
+		case "lint":
+			if err := runLint(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "audit":
+			if err := runAudit(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "batch":
+			if err := runBatch(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "bundle":
+			if err := runBundle(os.Args[2:], os.Stderr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			return
+		}
+	}
+	chip := new(Chip8)
+	chip.Init()
+	var set settings
+	set.register(flag.CommandLine)
+	var file = flag.String("file", "", "ROM or "+bundleExt+" bundle to run")
+	var frontend = flag.String("frontend", "auto", "frontend to use: auto, "+strings.Join(frontendNames(), ", "))
+	var pauseUnfocused = flag.Bool("pause-unfocused", false, "pause emulation while the window is unfocused")
+	var noDetect = flag.Bool("no-detect", false, "don't scan the ROM for other variants' opcodes to pick -variant and its quirks")
+	var traceEvery = flag.Int("trace-every", 1, "trace only every Nth instruction, plus all jumps, calls, returns and skips")
+	var traceMax = flag.Int64("trace-max", 0, "stop tracing after this many bytes (0 for no limit)")
+	var stateFmt = flag.String("state-format", "", stateFormatUsage)
+	var traceOut = flag.String("trace-out", "", "write the trace to this file in compressed binary form instead of stdout (read it with hapax8 trace cat)")
+	var profile = flag.Bool("profile", false, "time decoding, execution, drawing and presenting, and print per-frame statistics at exit")
+	var logFile = flag.String("log-file", "", "also write warnings and fault reports to this file")
+	var logMax = flag.Int64("log-max", 1<<20, "bytes the log file may reach before it is moved to .1 and restarted")
+	var export = flag.String("export", "", "send the display to this UDP host:port every frame")
+	var exportFmt = flag.String("export-format", "raw", "packets for -export: raw (256 bytes, one bit per pixel), osc ("+exportAddress+" with a blob) or framed (raw after a sync header)")
+	var serial = flag.String("serial", "", "write the display to this serial device whenever it's drawn to")
+	var serialFmt = flag.String("serial-format", "framed", "packets for -serial, as for -export-format")
+	var shotOn = flag.String("shot-on", "off", "save a PNG of the display and a JSON dump of the state when the program stops: off, fault, or stop for halts too; they go beside -log-file, or in the current directory")
+	var single = flag.Bool("single", false, "hand the ROM to an already running instance instead of starting another")
+	var watch = flag.Bool("watch", false, "when running a bundle, apply changes to its settings while it runs: speed, colors, display toggles, keys and buttons at once, the rest on F5")
+	var printKeymap = flag.Bool("print-keymap", false, "print the keymap, -keymap's changes included, in a form -keymap reads back, and exit")
+	flag.Parse()
+	keys, err := parseKeymap(set.Keymap, set.Keyboard)
+	if err == nil {
+		_, err = parseButtons(set.Buttons)
 	}
-	_, err = progFile.Read(c.memory[progStart:])
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-}
-
-// Init initializes the chip8 instance.
-func (c *Chip8) Init() {
-	c.inst = 0
-	c.index = 0
-	c.pc = progStart
-	c.sp = 0
-	c.delayTimer = 0
-	c.soundTimer = 0
-	c.memory = make([]uint8, memSize)
-	c.gfx = make([]uint8, 64*32)
-	for i, d := range fontSet {
-		c.memory[FONT_OFFSET+i] = d
+	if *printKeymap {
+		fmt.Print(keys.format(set.Keyboard))
+		return
 	}
-}
-
-// NewChip creates a new Chip8 instance loaded with the binary passed in
-func NewChip(bin string) *Chip8 {
-	c := new(Chip8)
-	c.Init()
-	c.LoadProgram(bin)
-	return c
-}
-
-// Decode decodes a single instruction.
-func (c *Chip8) Decode() {
-	topByte := bits.RotateLeft16(uint16(c.memory[c.pc]), 8) // shift the top byte up 8
-	bottomByte := uint16(c.memory[c.pc+1])
-	c.inst = topByte | bottomByte
-}
-
-// ToString prints out the chip's state: index, pc, sp, and reg block
-func (c *Chip8) ToString() string {
-	return fmt.Sprintf("Chip State:\n\tinst: %#x\n\tindex: %#x\n\tpc: %#x\n\tsp: %d\n\tregs: %+v\n", c.inst, c.index, c.pc, c.sp, c.v)
-}
-
-func topNibble(i uint16) uint16 {
-	return (i & 0xF000) >> 12
-}
-func bottomNibble(i uint16) uint16 {
-	return (i & 0x000F)
-}
-
-func bottomByte(i uint16) uint16 {
-	return i & 0x00FF
-}
-
-func targetAddr(i uint16) uint16 {
-	return (i & 0x0FFF)
-}
-
-// SetIndex sets the index register if current inst is ANNN
-func (c *Chip8) SetIndex() {
-	c.index = c.inst & 0x0FFF
-}
-
-// SetPC sets the PC register to the given address
-func (c *Chip8) SetPC(newaddr uint16) {
-	c.pc = newaddr
-}
-
-// IncPC increments the PC (adds 2 since the word is a short)
-func (c *Chip8) IncPC() {
-	c.pc += 2
-}
-
-// GetImm pulls out the immediate value from the current instruction.
-// numDigs is the number of hex digits to extract from the instruction.
-func (c *Chip8) GetImm(numDigs int) uint8 {
-	switch numDigs {
-	case 1:
-		return uint8(c.inst & 0x000F)
-	case 2:
-		return uint8(c.inst & 0x00FF)
-	case 3:
-		return uint8(c.inst & 0x0FFF)
-	default:
-		panic("bad arg")
+	if _, err := parseProtectMode(set.ProtectLow); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-}
-
-func (c *Chip8) GetXReg() uint16 {
-	return c.inst & 0x0F00 >> 8
-}
-
-func (c *Chip8) GetYReg() uint16 {
-	return c.inst & 0x00F0 >> 4
-}
-
-// Math8 executes the correct math instruction based on the bottom nibble of an inst starting with 0x8.
-func (c *Chip8) Math8() {
-	x := c.GetXReg()
-	y := c.GetYReg()
-	xVal := c.v[x]
-	yVal := c.v[y]
-	switch bottomNibble(c.inst) {
-	case 0x0:
-		c.v[x] = yVal
-	case 0x1:
-		c.v[x] = xVal | yVal
-	case 0x2:
-		c.v[x] = xVal & yVal
-	case 0x3:
-		c.v[x] = xVal ^ yVal
-	case 0x4:
-		add := xVal + yVal
-		if add > 255 {
-			c.v[0xF] = 1
-		}
-		c.v[x] = uint8(add & 0xFF)
-	case 0x5:
-		c.v[x] = xVal - yVal
-	case 0x6:
-		c.v[x] = xVal >> 1
-	case 0x7:
-		c.v[x] = yVal - xVal
-	case 0xE:
-		c.v[x] = xVal << 1
-	}
-}
-
-// Execute executes a single instruction.
-func (c *Chip8) Execute() {
-	c.Decode()
-	if c.inst == 0x0 {
-		return
+	shot, err := parseShotMode(*shotOn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	fmt.Println(c.ToString())
-	top := topNibble(c.inst)
-	x := c.GetXReg()
-	y := c.GetYReg()
-	switch top {
-	case 0x0:
-		switch bottomNibble(c.inst) {
-		// CLR
-		case 0x0:
-			fmt.Println("clear screen")
-			clear(c.gfx)
-		// RET
-		case 0xE:
-			fmt.Println("ret")
+	if *logFile != "" {
+		f, err := openRotating(*logFile, *logMax)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-		c.IncPC()
-	// JUMP
-	case 0x1:
-		c.SetPC(targetAddr(c.inst))
-	// CALL
-	case 0x2:
-		c.SetPC(targetAddr(c.inst))
-	// SKE
-	case 0x3:
-		imm := c.GetImm(2)
-		c.IncPC()
-		if imm == c.v[x] {
-			c.IncPC() // skip inst
+		defer f.Close()
+		diag = io.MultiWriter(os.Stderr, f)
+	}
+	for _, out := range []struct {
+		dest, format string
+		open         func(string, exportFormat) (*frameExporter, error)
+	}{
+		{*export, *exportFmt, newFrameExporter},
+		{*serial, *serialFmt, newSerialExporter},
+	} {
+		if out.dest == "" {
+			continue
 		}
-	// SKNE
-	case 0x4:
-		imm := c.GetImm(2)
-		c.IncPC()
-		if imm != c.v[x] {
-			c.IncPC()
+		format, err := parseExportFormat(out.format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-	// SKRE
-	case 0x5:
-		c.IncPC()
-		if c.v[x] == c.v[y] {
-			c.IncPC()
+		e, err := out.open(out.dest, format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	// LOAD
-	case 0x6:
-		imm := c.GetImm(2)
-		c.v[x] = imm
-		c.IncPC()
-	// ADD
-	case 0x7:
-		imm := c.GetImm(2)
-		c.v[x] += imm
-		c.IncPC()
-	// OR | AND | XOR | ADDR | SUB | SHR | SHL
-	case 0x8:
-		c.Math8()
-		c.IncPC()
-	// SKNRE
-	case 0x9:
-		c.IncPC()
-		if c.v[x] != c.v[y] {
-			c.IncPC()
+		defer e.Close()
+		chip.exports = append(chip.exports, e)
+	}
+	opts := &frontendOpts{pauseUnfocused: *pauseUnfocused, args: os.Args[1:], noDetect: *noDetect, shot: shot, shotDir: filepath.Dir(*logFile)}
+	for _, open := range keypadOpeners {
+		pad, err := open()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	// LOADI
-	case 0xA:
-		c.SetIndex()
-		c.IncPC()
-	case 0xD:
-		// Get address in I
-		// memory[I:I+n] -> gfx[x+y]
-		x := c.v[c.GetXReg()]
-		y := c.v[c.GetYReg()]
-		n := c.GetImm(1)
-		spriteAddr := c.index
-		spriteLength := n
-		var j uint8
-		for i := spriteAddr; i < uint16(spriteLength+uint8(spriteAddr)); i++ {
-			c.gfx[64*x+y+j] = c.memory[i]
-			j++
+		if pad != nil {
+			opts.pads = append(opts.pads, &padInput{pad: pad})
 		}
-		c.IncPC()
-	case 0xF:
-		bottom := bottomByte(c.inst)
-		switch bottom {
-		// STOR
-		case 0x55:
-			c.memory[c.index] = c.v[x]
-			c.IncPC()
-		// READ
-		case 0x65:
-			c.v[x] = c.memory[c.index]
-			c.IncPC()
+	}
+	chip.traceEvery = *traceEvery
+	if *stateFmt != "" {
+		if chip.stateFormat, err = parseStateFormat(*stateFmt); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
 	}
-
-	if c.delayTimer > 0 {
-		c.delayTimer--
+	if *traceMax > 0 {
+		chip.trace = &limitWriter{w: os.Stdout, max: *traceMax}
 	}
-
-	if c.soundTimer > 0 {
-		c.soundTimer--
+	if *traceOut != "" {
+		f, err := os.Create(*traceOut)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		chip.trace = io.Discard
+		chip.binTrace = newBinaryTrace(f)
+		defer chip.binTrace.Close()
 	}
-
-}
-
-func main() {
-	chip := new(Chip8)
-	chip.Init()
-	var file = flag.String("file", "", "file to run")
-	flag.Parse()
-	chip.LoadProgram(*file)
-
-	// for {
-	// 	chip.Execute()
-	// }
-
-	// Set up window and canvas
-	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
-		panic(err)
+	if *single {
+		if *file != "" {
+			rom, err := filepath.Abs(*file)
+			if _, serr := os.Stat(rom); err == nil && serr == nil && handOff(rom) {
+				return
+			}
+		}
+		roms, l, err := listenHandoff()
+		if err != nil {
+			fmt.Fprintln(diag, "single instance:", err)
+		} else {
+			defer l.Close()
+			opts.handoff = roms
+		}
 	}
-	defer sdl.Quit()
 
-	window, err := sdl.CreateWindow("test", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
-		1000, 1000, sdl.WINDOW_SHOWN)
+	fe, err := openFrontend(*frontend, opts)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	defer window.Destroy()
-
-	surface, err := window.GetSurface()
+	if *file == "" {
+		// Double-clicked GUI users get a file chooser instead of a panic.
+		picker, ok := fe.(romPicker)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "no ROM given: use -file")
+			os.Exit(1)
+		}
+		if *file, err = picker.PickROM(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *file == "" {
+			return // cancelled
+		}
+	}
+	rom, b, err := readROM(*file)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	surface.FillRect(nil, 0)
-
-	// offset := 0x55
-	// chip.drawLetter(surface, window, offset, 40, 50)
-
-	running := true
-	// for i := 0; i < FONT_OFFSET+FONTSET_SIZE; i++ {
-	// 	chip.gfx[i] = chip.memory[FONT_OFFSET+i]
-	// }
-	for running {
-		chip.Execute()
-		chip.drawMemory(surface, window)
-		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-			switch event.(type) {
-			case *sdl.QuitEvent:
-				println("Quit")
-				running = false
-				break
-			}
-		}
+	if set, err = opts.start(chip, rom, b); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-}
-
-func (c *Chip8) drawMemory(surface *sdl.Surface, window *sdl.Window) {
-	for i := 0; i < len(c.gfx); i++ {
-		data := bits.Reverse8(c.gfx[i])
-		for j := 0; j < 8; j++ {
-			pixelVal := (data & (1 << j)) >> j
-			rect := sdl.Rect{X: int32((j * 10)), Y: int32((i * 10)), W: 10, H: 10}
-			color := sdl.Color{}
-			if pixelVal == 1 {
-				color = sdl.Color{R: 255, G: 255, B: 255, A: 255}
-			} else {
-				color = sdl.Color{R: 0, G: 0, B: 0, A: 0}
-			}
-			pixel := sdl.MapRGBA(surface.Format, color.R, color.G, color.B, color.A)
-
-			surface.FillRect(&rect, pixel)
+	chip.shot = newStopShot(opts.shot, opts.shotDir, *file, rom, set) // after detection has had its say
+	if *watch {
+		if b == nil {
+			fmt.Fprintln(diag, "warning: -watch only follows bundles; "+*file+" isn't one")
+		} else {
+			opts.watch = newBundleWatch(*file, rom, set, func(s settings) settings {
+				set = s
+				flag.Parse() // flags given still win, as they did at startup
+				return set
+			})
 		}
 	}
-	window.UpdateSurface()
-}
-
-func (c *Chip8) drawLetter(surface *sdl.Surface, window *sdl.Window, offset, x, y int) {
-	for i := 0; i < 5; i++ {
-		data := bits.Reverse8(c.memory[offset+i])
-		for j := 0; j < 8; j++ {
-			pixelVal := (data & (1 << j)) >> j
-			rect := sdl.Rect{X: int32((j * 10) + x), Y: int32((i * 10) + y), W: 10, H: 10}
-			color := sdl.Color{}
-			if pixelVal == 1 {
-				color = sdl.Color{R: 255, G: 255, B: 255, A: 255}
-			} else {
-				color = sdl.Color{R: 0, G: 0, B: 0, A: 0}
-			}
-
-			pixel := sdl.MapRGBA(surface.Format, color.R, color.G, color.B, color.A)
-
-			surface.FillRect(&rect, pixel)
-		}
+	if *profile {
+		chip.prof = new(profiler)
+	}
+	err = fe.Run(chip)
+	chip.reportUnsupported(diag)
+	chip.prof.report(diag)
+	if err != nil {
+		fmt.Fprintln(diag, err)
+		os.Exit(1)
 	}
-	window.UpdateSurface()
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+)
+
+// minimizeSeed seeds CXNN during minimization so every run of a candidate
+// ROM behaves the same; the emitted test uses it too.
+const minimizeSeed = 1
+
+// nopWord stands in for removed code. Zeroed code is an unknown opcode,
+// which faults under -unknown-ops=halt; 0001 is an ignored machine code
+// call that only moves on to the next instruction.
+var nopWord = [2]uint8{0x00, 0x01}
+
+// faultRun runs rom under set for up to maxSteps instructions and returns
+// the fault it stopped with, if any, and how many instructions that took.
+// A run that halts ends early without a fault.
+func faultRun(rom []uint8, set settings, maxSteps int) (fault string, steps int) {
+	c := new(Chip8)
+	c.trace = io.Discard
+	c.rand = rand.New(rand.NewSource(minimizeSeed))
+	c.Init()
+	if err := set.apply(c, new(frontendOpts)); err != nil {
+		return "", 0
+	}
+	c.LoadROM(rom)
+	for steps = 1; steps <= maxSteps; steps++ {
+		c.Execute()
+		if c.fault != nil {
+			return c.fault.Error(), steps
+		}
+		// 0000 and unknown opcodes go on past them, or fault, as in a
+		// real run.
+		if c.halted {
+			break
+		}
+	}
+	return "", 0
+}
+
+// minimizeROM blanks as much of rom as it can while it still stops with the
+// same fault, then drops the trailing blanks. It tries blanking runs of
+// decreasing size, zeroing them as data first and filling them with nopWord
+// as code if that changes the outcome.
+func minimizeROM(rom []uint8, set settings, maxSteps int) ([]uint8, string, error) {
+	want, _ := faultRun(rom, set, maxSteps)
+	if want == "" {
+		return nil, "", fmt.Errorf("ROM doesn't fault within %d instructions", maxSteps)
+	}
+	rom = append([]uint8(nil), rom...)
+	try := make([]uint8, len(rom))
+	for size := len(rom) / 2 &^ 1; size >= 2; size = size / 2 &^ 1 {
+		for at := 0; at < len(rom); at += size {
+			end := min(at+size, len(rom))
+			for _, fill := range []func(i int) uint8{
+				func(int) uint8 { return 0 },
+				func(i int) uint8 { return nopWord[i%2] },
+			} {
+				copy(try, rom)
+				changed := false
+				for i := at; i < end; i++ {
+					try[i] = fill(i)
+					changed = changed || try[i] != rom[i]
+				}
+				if !changed {
+					break
+				}
+				if got, _ := faultRun(try, set, maxSteps); got == want {
+					copy(rom, try)
+					break
+				}
+			}
+		}
+	}
+	for len(rom) > 0 && rom[len(rom)-1] == 0 {
+		if got, _ := faultRun(rom[:len(rom)-1], set, maxSteps); got != want {
+			break
+		}
+		rom = rom[:len(rom)-1]
+	}
+	return rom, want, nil
+}
+
+// faultTest is the Go test writeFaultTest fills in.
+const faultTest = `// TestMinimizedFault reproduces %[1]q
+func TestMinimizedFault(t *testing.T) {
+	chip := new(Chip8)
+	chip.trace = io.Discard
+	chip.rand = rand.New(rand.NewSource(%[2]d))
+	chip.Init()
+	set := %[3]s
+	if err := set.apply(chip, new(frontendOpts)); err != nil {
+		t.Fatal(err)
+	}
+	chip.LoadROM([]uint8{
+%[4]s	})
+	for i := 0; i < %[5]d; i++ {
+		chip.Execute()
+	}
+	if chip.Fault() == nil || chip.Fault().Error() != %[1]q {
+		t.Errorf("Got fault %%v", chip.Fault())
+	}
+}
+`
+
+// writeFaultTest writes a Go test that loads rom and checks it still stops
+// with fault after steps instructions.
+func writeFaultTest(w io.Writer, rom []uint8, set settings, fault string, steps int) {
+	var data strings.Builder
+	for i := 0; i < len(rom); i += 8 {
+		data.WriteString("\t\t")
+		for j, b := range rom[i:min(i+8, len(rom))] {
+			if j > 0 {
+				data.WriteByte(' ')
+			}
+			fmt.Fprintf(&data, "0x%02X,", b)
+		}
+		data.WriteByte('\n')
+	}
+	lit := strings.TrimPrefix(fmt.Sprintf("%#v", set), "main.")
+	fmt.Fprintf(w, faultTest, fault, minimizeSeed, lit, data.String(), steps)
+}
+
+// runMinimize implements "hapax8 minimize [flags] rom.ch8".
+func runMinimize(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("minimize", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var set settings
+	set.register(fs)
+	maxSteps := fs.Int("steps", 1000000, "instructions to run looking for the fault")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hapax8 minimize [flags] rom.ch8")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("minimize takes exactly one ROM")
+	}
+	rom, b, err := readROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		// As when running a bundle, flags given here override it.
+		set = b.Settings
+		fs.Parse(args)
+	}
+	small, fault, err := minimizeROM(rom, set, *maxSteps)
+	if err != nil {
+		return err
+	}
+	_, steps := faultRun(small, set, *maxSteps)
+	fmt.Fprintf(stderr, "minimized %d bytes to %d\n", len(rom), len(small))
+	writeFaultTest(stdout, small, set, fault, steps)
+	return nil
+}
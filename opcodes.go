@@ -0,0 +1,710 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// opcode is one entry in the instruction table. Execute dispatches through
+// it, the assembler takes its mnemonics from it and "hapax8 help opcodes"
+// prints it, so the three can't disagree.
+type opcode struct {
+	mask, match uint16
+	pattern     string // the opcode as usually written, e.g. "8XY4"
+	name        string // chip8asm mnemonic; empty if it has none
+	args        string // assembler operands, see mnemonic
+	summary     string
+	quirks      string // where interpreters disagree, if anywhere
+	exec        func(c *Chip8)
+}
+
+// opcodes is searched in order, so exact forms come before the catch-all
+// of the same leading nibble.
+var opcodes = []opcode{
+	{0xFFFF, 0x00E0, "00E0", "CLR", "", "clear the display; in MegaChip mode, first show what has been drawn", "", (*Chip8).opClear},
+	{0xFFFF, 0x00EE, "00EE", "RET", "", "return from subroutine", "", (*Chip8).opReturn},
+	{0xFFF0, 0x00D0, "00DN", "", "n", "XO-CHIP: scroll the selected planes up N rows", "", (*Chip8).opScrollUp},
+	{0xFFF0, 0x00B0, "00BN", "", "n", "MegaChip: scroll the display up N rows", "", (*Chip8).opMegaScrollUp},
+	{0xFFF0, 0x00C0, "00CN", "SCRD", "n", "SCHIP: scroll the display down N rows", "", (*Chip8).opScrollDown},
+	{0xFFFF, 0x00FB, "00FB", "SCRR", "", "SCHIP: scroll the display right 4 pixels", "", (*Chip8).opScrollRight},
+	{0xFFFF, 0x00FC, "00FC", "SCRL", "", "SCHIP: scroll the display left 4 pixels", "", (*Chip8).opScrollLeft},
+	{0xFFFF, 0x00FD, "00FD", "EXIT", "", "SCHIP: exit the interpreter", "", (*Chip8).opExit},
+	{0xFFFF, 0x00FE, "00FE", "EXTD", "", "SCHIP: switch to the 64x32 display", "", (*Chip8).opLores},
+	{0xFFFF, 0x00FF, "00FF", "EXTE", "", "SCHIP: switch to the 128x64 display", "", (*Chip8).opHires},
+	{0xFFFF, 0x0010, "0010", "", "", "MegaChip: go back to the SCHIP display", "", (*Chip8).opMegaOff},
+	{0xFFFF, 0x0011, "0011", "", "", "MegaChip: switch to the 256x192 color display", "", (*Chip8).opMegaOn},
+	{0xFF00, 0x0100, "01NN", "", "", "MegaChip: I = NN and the 16-bit word after this instruction, 24 bits in all", "", (*Chip8).opMegaIndex},
+	{0xFFFF, 0x0230, "0230", "", "", "hi-res CHIP-8: clear the 64x64 display", "", (*Chip8).opHiresClear},
+	{0xFFFF, 0x02A0, "02A0", "", "", "CHIP-8X: step the background color through blue, black, green and red", "", (*Chip8).opBackground},
+	{0xFF00, 0x0200, "02NN", "", "", "MegaChip: load NN colors from I, 4 bytes each as ARGB, into palette entries 1 to NN", "", (*Chip8).opPalette},
+	{0xFF00, 0x0300, "03NN", "", "", "MegaChip: sprite width = NN pixels, 0 for 256", "", (*Chip8).opSpriteWidth},
+	{0xFF00, 0x0400, "04NN", "", "", "MegaChip: sprite height = NN pixels, 0 for 256", "", (*Chip8).opSpriteHeight},
+	{0xFF00, 0x0500, "05NN", "", "", "MegaChip: display opacity = NN", "", (*Chip8).opScreenAlpha},
+	{0xFFF0, 0x0600, "060N", "", "", "MegaChip: play the digitized sound at I, looping unless N is 0 (not emulated)", "", (*Chip8).opMegaUnsupported},
+	{0xFFFF, 0x0700, "0700", "", "", "MegaChip: stop the digitized sound (not emulated)", "", (*Chip8).opMegaUnsupported},
+	{0xFFF0, 0x0800, "080N", "", "", "MegaChip: sprite blend mode N; only 0, normal, is emulated", "", (*Chip8).opMegaUnsupported},
+	{0xFF00, 0x0900, "09NN", "", "", "MegaChip: drawing over palette index NN sets VF", "", (*Chip8).opCollideColor},
+	{0xF000, 0x0000, "0NNN", "", "", "call machine code routine at NNN (ignored)", "", (*Chip8).opSys},
+	{0xF000, 0x1000, "1NNN", "JUMP", "a", "jump to NNN; a jump to itself halts", "", (*Chip8).opJump},
+	{0xF000, 0x2000, "2NNN", "CALL", "a", "call subroutine at NNN", "", (*Chip8).opCall},
+	{0xF000, 0x3000, "3XNN", "SKE", "xb", "skip next if VX == NN", "", (*Chip8).opSkipEqImm},
+	{0xF000, 0x4000, "4XNN", "SKNE", "xb", "skip next if VX != NN", "", (*Chip8).opSkipNeImm},
+	{0xF00F, 0x5000, "5XY0", "SKRE", "xy", "skip next if VX == VY", "", (*Chip8).opSkipEq},
+	{0xF00F, 0x5001, "5XY1", "", "xy", "CHIP-8X: add VY to VX a nibble at a time, each wrapping at 8", "", (*Chip8).opNibbleAdd},
+	{0xF00F, 0x5002, "5XY2", "", "xy", "XO-CHIP: store VX..VY at I, leaving I alone", "", (*Chip8).opSaveRange},
+	{0xF00F, 0x5003, "5XY3", "", "xy", "XO-CHIP: read VX..VY from I, leaving I alone", "", (*Chip8).opLoadRange},
+	{0xF000, 0x6000, "6XNN", "LOAD", "xb", "VX = NN", "", (*Chip8).opLoad},
+	{0xF000, 0x7000, "7XNN", "ADD", "xb", "VX += NN; VF is untouched", "", (*Chip8).opAdd},
+	{0xF00F, 0x8000, "8XY0", "MOVE", "xy", "VX = VY", "", (*Chip8).opMath},
+	{0xF00F, 0x8001, "8XY1", "OR", "xy", "VX |= VY", "COSMAC VIP resets VF to 0 (-quirk-logic-vf)", (*Chip8).opMath},
+	{0xF00F, 0x8002, "8XY2", "AND", "xy", "VX &= VY", "COSMAC VIP resets VF to 0 (-quirk-logic-vf)", (*Chip8).opMath},
+	{0xF00F, 0x8003, "8XY3", "XOR", "xy", "VX ^= VY", "COSMAC VIP resets VF to 0 (-quirk-logic-vf)", (*Chip8).opMath},
+	{0xF00F, 0x8004, "8XY4", "ADDR", "xy", "VX += VY; VF = carry", "", (*Chip8).opMath},
+	{0xF00F, 0x8005, "8XY5", "SUB", "xy", "VX -= VY; VF = 1 if there was no borrow", "", (*Chip8).opMath},
+	{0xF00F, 0x8006, "8XY6", "SHR", "xy", "VX = VY >> 1; VF = the bit shifted out", "CHIP-48 and SCHIP shift VX in place instead (-quirk-shift)", (*Chip8).opMath},
+	{0xF00F, 0x8007, "8XY7", "SUBN", "xy", "VX = VY - VX; VF = 1 if there was no borrow", "", (*Chip8).opMath},
+	{0xF00F, 0x800E, "8XYE", "SHL", "xy", "VX = VY << 1; VF = the bit shifted out", "CHIP-48 and SCHIP shift VX in place instead (-quirk-shift)", (*Chip8).opMath},
+	{0xF00F, 0x9000, "9XY0", "SKNRE", "xy", "skip next if VX != VY", "", (*Chip8).opSkipNe},
+	{0xF000, 0xA000, "ANNN", "LOADI", "a", "I = NNN", "", (*Chip8).opLoadIndex},
+	{0xF000, 0xB000, "BNNN", "JUMPI", "a", "jump to NNN + V0", "CHIP-48 and SCHIP jump to XNN + VX instead (-quirk-jump-offset); CHIP-8X replaces it with BXYN, which colors the zones VX and VX+1 give in color VY, 8x4 pixels each or 8x1 for N > 0", (*Chip8).opJumpOffset},
+	{0xF000, 0xC000, "CXNN", "RAND", "xb", "VX = random byte & NN", "", (*Chip8).opRand},
+	{0xF000, 0xD000, "DXYN", "DRAW", "xyn", "XOR the N-row sprite at I onto the display at (VX, VY); VF = 1 if a lit pixel was erased. In MegaChip mode it draws the 03NN x 04NN color sprite at I instead", "sprites are clipped at the edges here, some interpreters wrap them (-quirk-wrap); COSMAC VIP waits for vblank (-quirk-display-wait); SCHIP draws a 16x16 sprite for N = 0", (*Chip8).opDraw},
+	{0xF0FF, 0xE09E, "EX9E", "SKPR", "x", "skip next if the key in VX is down", "", (*Chip8).opSkipKey},
+	{0xF0FF, 0xE0A1, "EXA1", "SKUP", "x", "skip next if the key in VX is up", "", (*Chip8).opSkipNoKey},
+	{0xF0FF, 0xE0F2, "EXF2", "", "x", "CHIP-8X: skip next if the key in VX is down on the second keypad", "", (*Chip8).opSkipKey2},
+	{0xF0FF, 0xE0F5, "EXF5", "", "x", "CHIP-8X: skip next if the key in VX is up on the second keypad", "", (*Chip8).opSkipNoKey2},
+	{0xFFFF, 0xF000, "F000", "", "", "XO-CHIP: I = the 16-bit word after this instruction", "", (*Chip8).opLongIndex},
+	{0xF0FF, 0xF001, "FN01", "", "x", "XO-CHIP: select display planes N (bit 0 first, bit 1 second) for drawing, clearing and scrolling", "", (*Chip8).opPlanes},
+	{0xFFFF, 0xF002, "F002", "", "", "XO-CHIP: load the 16-byte audio pattern from I", "", (*Chip8).opAudioPattern},
+	{0xF0FF, 0xF007, "FX07", "MOVED", "x", "VX = delay timer", "", (*Chip8).opReadDelay},
+	{0xF0FF, 0xF00A, "FX0A", "KEYD", "x", "wait for a key to be pressed and released; VX = the key", "some interpreters finish on the press instead (-quirk-key-press)", (*Chip8).opWaitKey},
+	{0xF0FF, 0xF015, "FX15", "LOADD", "x", "delay timer = VX", "", (*Chip8).opSetDelay},
+	{0xF0FF, 0xF018, "FX18", "LOADS", "x", "sound timer = VX", "", (*Chip8).opSetSound},
+	{0xF0FF, 0xF01E, "FX1E", "ADDI", "x", "I += VX", "the Amiga interpreter sets VF when I passes 0xFFF (-quirk-index-overflow)", (*Chip8).opAddIndex},
+	{0xF0FF, 0xF029, "FX29", "LDSPR", "x", "I = address of the font glyph for the digit in VX", "", (*Chip8).opFontChar},
+	{0xF0FF, 0xF030, "FX30", "", "x", "SCHIP: point I at the 8x10 glyph for the digit in VX", "", (*Chip8).opBigFontChar},
+	{0xF0FF, 0xF033, "FX33", "BCD", "x", "store the decimal digits of VX at I, I+1 and I+2", "", (*Chip8).opBCD},
+	{0xF0FF, 0xF03A, "FX3A", "", "x", "XO-CHIP: audio pattern pitch = VX", "", (*Chip8).opPitch},
+	{0xF0FF, 0xF055, "FX55", "STOR", "x", "store V0..VX at I", "COSMAC VIP leaves I at I+X+1; CHIP-48 at I+X; SCHIP leaves it unchanged (-quirk-load-store)", (*Chip8).opStore},
+	{0xF0FF, 0xF065, "FX65", "READ", "x", "read V0..VX from I", "COSMAC VIP leaves I at I+X+1; CHIP-48 at I+X; SCHIP leaves it unchanged (-quirk-load-store)", (*Chip8).opRead},
+	{0xF0FF, 0xF075, "FX75", "SRPL", "x", "SCHIP: save V0..VX to the RPL user flags", "", (*Chip8).opSaveFlags},
+	{0xF0FF, 0xF085, "FX85", "LRPL", "x", "SCHIP: load V0..VX from the RPL user flags", "", (*Chip8).opLoadFlags},
+}
+
+// lookupOpcode returns the table entry for inst, or nil if it isn't supported.
+func lookupOpcode(inst uint16) *opcode {
+	for i := range opcodes {
+		if inst&opcodes[i].mask == opcodes[i].match {
+			return &opcodes[i]
+		}
+	}
+	return nil
+}
+
+func (c *Chip8) opClear() {
+	fmt.Fprintln(c.trace, "clear screen")
+	if c.mega.on {
+		c.presentMega()
+		return
+	}
+	// Only the selected planes are cleared; outside XO-CHIP that's all of them.
+	for i := range c.gfx {
+		c.gfx[i] &^= c.planes
+	}
+	c.displayChanged()
+	c.IncPC()
+}
+
+func (c *Chip8) opHiresClear() {
+	if c.variant == variantMegaChip {
+		c.opPalette() // 02NN, for NN = 30
+		return
+	}
+	if c.needs(variantHires) {
+		c.opClear()
+	}
+}
+
+func (c *Chip8) opScrollDown() {
+	if c.needs(variantSCHIP) {
+		c.scroll(0, int(bottomNibble(c.inst)))
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opScrollUp() {
+	if c.needs(variantXOCHIP) {
+		c.scroll(0, -int(bottomNibble(c.inst)))
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opMegaScrollUp() {
+	if c.needs(variantMegaChip) {
+		c.scroll(0, -int(bottomNibble(c.inst)))
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opScrollRight() {
+	if c.needs(variantSCHIP) {
+		c.scroll(4, 0)
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opScrollLeft() {
+	if c.needs(variantSCHIP) {
+		c.scroll(-4, 0)
+		c.IncPC()
+	}
+}
+
+// scroll moves the selected planes dx pixels right and dy down, negative
+// for left and up, blanking what scrolls in. Planes that aren't selected
+// stay put.
+func (c *Chip8) scroll(dx, dy int) {
+	w, h := c.DisplaySize()
+	s := c.screen()
+	// Walk against the direction of travel so each pixel is read before
+	// it's overwritten.
+	for j := 0; j < h; j++ {
+		y := j
+		if dy > 0 {
+			y = h - 1 - j
+		}
+		for i := 0; i < w; i++ {
+			x := i
+			if dx > 0 {
+				x = w - 1 - i
+			}
+			var moved uint8
+			if sx, sy := x-dx, y-dy; sx >= 0 && sx < w && sy >= 0 && sy < h {
+				moved = s[sy*w+sx] & c.planes
+			}
+			p := &s[y*w+x]
+			*p = *p&^c.planes | moved
+		}
+	}
+	c.displayChanged()
+}
+
+func (c *Chip8) opExit() {
+	if c.needs(variantSCHIP) {
+		c.halted = true
+	}
+}
+
+func (c *Chip8) opLores() {
+	if c.needs(variantSCHIP) {
+		c.setHires(false)
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opHires() {
+	if c.needs(variantSCHIP) {
+		c.setHires(true)
+		c.IncPC()
+	}
+}
+
+// setHires switches the display size, clearing it as Octo does; what was
+// drawn at one size means nothing at the other. Unlike 00E0 this clears
+// every plane.
+func (c *Chip8) setHires(on bool) {
+	c.hires = on
+	clear(c.gfx)
+	c.displayChanged()
+}
+
+// displayChanged notes a write to the display for the frontend and the
+// next checkpoint.
+func (c *Chip8) displayChanged() {
+	c.drawn = true
+	c.gfxDirty = true
+}
+
+// skipNext steps over the instruction at the PC for a skip that was taken.
+// XO-CHIP's F000 NNNN and MegaChip's 01NN NNNN are four bytes long, so they
+// take two steps.
+func (c *Chip8) skipNext() {
+	if c.variant == variantXOCHIP && c.memory[c.pc] == 0xF0 && c.memory[c.pc+1] == 0x00 ||
+		c.variant == variantMegaChip && c.memory[c.pc] == 0x01 {
+		c.IncPC()
+	}
+	c.IncPC()
+}
+
+func (c *Chip8) opSaveRange() {
+	if c.needs(variantXOCHIP) {
+		x, y := c.GetXReg(), c.GetYReg()
+		for i, r := range regRange(x, y) {
+			c.writeMem(c.index+uint16(i), c.v[r])
+			if c.fault != nil {
+				return
+			}
+		}
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opLoadRange() {
+	if c.needs(variantXOCHIP) {
+		x, y := c.GetXReg(), c.GetYReg()
+		for i, r := range regRange(x, y) {
+			c.v[r] = c.readMem(c.index + uint16(i))
+		}
+		c.IncPC()
+	}
+}
+
+// regRange lists the registers from x to y inclusive, counting down if y is
+// below x, as 5XY2 and 5XY3 take them.
+func regRange(x, y uint16) []uint16 {
+	var regs []uint16
+	for r := x; ; {
+		regs = append(regs, r)
+		if r == y {
+			return regs
+		}
+		if y > x {
+			r++
+		} else {
+			r--
+		}
+	}
+}
+
+func (c *Chip8) opLongIndex() {
+	if c.needs(variantXOCHIP) {
+		c.index = uint16(c.memory[c.pc+2])<<8 | uint16(c.memory[c.pc+3])
+		c.IncPC()
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opPlanes() {
+	if c.needs(variantXOCHIP) {
+		c.planes = uint8(c.GetXReg()) & 3
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opAudioPattern() {
+	if c.needs(variantXOCHIP) {
+		for i := range c.pattern {
+			c.pattern[i] = c.readMem(c.index + uint16(i))
+		}
+		c.patterned = true
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opPitch() {
+	if c.needs(variantXOCHIP) {
+		c.pitch = c.v[c.GetXReg()]
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opBackground() {
+	if c.variant == variantMegaChip {
+		c.opPalette() // 02NN, for NN = A0
+		return
+	}
+	if c.needs(variantCHIP8X) {
+		c.background = (c.background + 1) % uint8(len(chip8xBackgrounds))
+		c.displayChanged()
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opNibbleAdd() {
+	if c.needs(variantCHIP8X) {
+		x := c.GetXReg()
+		c.v[x] = (c.v[x]&0x77 + c.v[c.GetYReg()]&0x77) & 0x77
+		c.IncPC()
+	}
+}
+
+// opZoneColor is CHIP-8X's BXYN. The display is colored in zones 8 pixels
+// wide and 4 rows high, or 1 row when N isn't 0. VX gives the left zone in
+// its low nibble and how many more to the right in its high nibble; VX+1
+// does the same for the top zone and how many more below.
+func (c *Chip8) opZoneColor() {
+	x := c.GetXReg()
+	across, down := c.v[x], c.v[(x+1)&0xF]
+	rows := 4
+	if bottomNibble(c.inst) != 0 {
+		rows = 1
+	}
+	col := c.v[c.GetYReg()] & 7
+	for zy := int(down & 0xF); zy <= int(down&0xF+down>>4); zy++ {
+		for zx := int(across & 0xF); zx <= int(across&0xF+across>>4) && zx < len(c.zones[0]); zx++ {
+			for y := zy * rows; y < (zy+1)*rows && y < len(c.zones); y++ {
+				c.zones[y][zx] = col
+			}
+		}
+	}
+	c.displayChanged()
+	c.IncPC()
+}
+
+func (c *Chip8) opReturn() {
+	if c.sp == 0 {
+		c.fault = fmt.Errorf("stack underflow: return at %#x with no call to return from", c.pc)
+		return
+	}
+	c.sp--
+	c.SetPC(c.stack[c.sp])
+}
+
+func (c *Chip8) opSys() {
+	c.noteUnsupported()
+	c.IncPC()
+}
+
+func (c *Chip8) opJump() {
+	// Most ROMs end on a jump to themselves; stop there instead of spinning.
+	if targetAddr(c.inst) == c.pc {
+		c.halted = true
+	}
+	if c.variant == variantHires && c.inst == hiresEntry && c.pc == defaultProgStart {
+		// The jump into the hi-res interpreter's setup, which the ROM
+		// carries below its own code; that setup is what this variant does.
+		c.SetPC(hiresStart)
+		return
+	}
+	c.SetPC(targetAddr(c.inst))
+}
+
+func (c *Chip8) opCall() {
+	if int(c.sp) == len(c.stack) {
+		c.fault = fmt.Errorf("stack overflow: call at %#x nests deeper than %d", c.pc, len(c.stack))
+		return
+	}
+	c.stack[c.sp] = c.pc + 2
+	c.sp++
+	c.SetPC(targetAddr(c.inst))
+}
+
+func (c *Chip8) opSkipEqImm() {
+	imm := c.GetImm(2)
+	c.IncPC()
+	if imm == c.v[c.GetXReg()] {
+		c.skipNext() // skip inst
+	}
+}
+
+func (c *Chip8) opSkipNeImm() {
+	imm := c.GetImm(2)
+	c.IncPC()
+	if imm != c.v[c.GetXReg()] {
+		c.skipNext()
+	}
+}
+
+func (c *Chip8) opSkipEq() {
+	c.IncPC()
+	if c.v[c.GetXReg()] == c.v[c.GetYReg()] {
+		c.skipNext()
+	}
+}
+
+func (c *Chip8) opSkipNe() {
+	c.IncPC()
+	if c.v[c.GetXReg()] != c.v[c.GetYReg()] {
+		c.skipNext()
+	}
+}
+
+func (c *Chip8) opLoad() {
+	c.v[c.GetXReg()] = c.GetImm(2)
+	c.IncPC()
+}
+
+func (c *Chip8) opAdd() {
+	c.v[c.GetXReg()] += c.GetImm(2)
+	c.IncPC()
+}
+
+func (c *Chip8) opMath() {
+	c.Math8()
+	c.IncPC()
+}
+
+func (c *Chip8) opLoadIndex() {
+	c.SetIndex()
+	c.IncPC()
+}
+
+func (c *Chip8) opJumpOffset() {
+	if c.variant == variantCHIP8X {
+		c.opZoneColor()
+		return
+	}
+	// BXNN: X is both the top nibble of the address and the register.
+	r := uint16(0)
+	if c.quirks.JumpOffset {
+		r = c.GetXReg()
+	}
+	c.SetPC(targetAddr(c.inst) + uint16(c.v[r]))
+}
+
+func (c *Chip8) opRand() {
+	c.v[c.GetXReg()] = uint8(c.rand.Uint32()) & c.GetImm(2)
+	c.IncPC()
+}
+
+func (c *Chip8) opDraw() {
+	if c.quirks.DisplayWait {
+		if !c.vblank {
+			// Leave the PC here and try again next frame.
+			c.waiting = true
+			return
+		}
+		c.vblank = false
+	}
+	if c.mega.on {
+		c.drawMega()
+		return
+	}
+	// The start wraps onto the display; the sprite is clipped at its edges
+	// unless the wrap quirk wraps it too.
+	w, h := c.DisplaySize()
+	x0 := int(c.v[c.GetXReg()]) % w
+	y0 := int(c.v[c.GetYReg()]) % h
+	n, width := int(c.GetImm(1)), 8
+	if n == 0 && c.variant.has(variantSCHIP) {
+		n, width = 16, 16 // two bytes a row
+	}
+	c.v[0xF] = 0
+	// Each selected plane takes the next sprite's worth of bytes from I.
+	addr := c.index
+	for plane := uint8(1); plane <= 2; plane <<= 1 {
+		if c.planes&plane == 0 {
+			continue
+		}
+		for row := 0; row < n; row, addr = row+1, addr+uint16(width/8) {
+			y := y0 + row
+			if y >= h {
+				if !c.quirks.Wrap {
+					continue
+				}
+				y -= h
+			}
+			bits := uint16(c.readMem(addr)) << 8
+			if width == 16 {
+				bits |= uint16(c.readMem(addr + 1))
+			}
+			for col := 0; col < width; col++ {
+				x := x0 + col
+				if x >= w {
+					if !c.quirks.Wrap {
+						break
+					}
+					x -= w
+				}
+				if bits&(0x8000>>col) == 0 {
+					continue
+				}
+				p := &c.gfx[y*w+x]
+				if *p&plane != 0 {
+					c.v[0xF] = 1
+				}
+				*p ^= plane
+			}
+		}
+	}
+	c.displayChanged()
+	c.IncPC()
+}
+
+func (c *Chip8) opSkipKey() {
+	c.IncPC()
+	if c.pad()[c.v[c.GetXReg()]&0xF] {
+		c.skipNext()
+	}
+}
+
+func (c *Chip8) opSkipNoKey() {
+	c.IncPC()
+	if !c.pad()[c.v[c.GetXReg()]&0xF] {
+		c.skipNext()
+	}
+}
+
+func (c *Chip8) opSkipKey2() {
+	if c.needs(variantCHIP8X) {
+		c.IncPC()
+		if c.pad2()[c.v[c.GetXReg()]&0xF] {
+			c.skipNext()
+		}
+	}
+}
+
+func (c *Chip8) opSkipNoKey2() {
+	if c.needs(variantCHIP8X) {
+		c.IncPC()
+		if !c.pad2()[c.v[c.GetXReg()]&0xF] {
+			c.skipNext()
+		}
+	}
+}
+
+// opWaitKey leaves the PC on the FX0A until a key goes down and, as on the
+// COSMAC VIP, comes back up, then puts the key in VX. The keypad only
+// changes between frames, so the rest of each frame it waits is skipped.
+func (c *Chip8) opWaitKey() {
+	w := &c.keyWait
+	keys := c.pad()
+	if !w.active {
+		*w = keyWait{active: true, before: keys, key: -1}
+		c.waiting = true
+		return
+	}
+	for k, down := range keys {
+		if down && !w.before[k] && w.key < 0 {
+			w.key = int8(k)
+		}
+	}
+	w.before = keys
+	if w.key >= 0 && (c.quirks.KeyPress || !keys[w.key]) {
+		c.v[c.GetXReg()] = uint8(w.key)
+		w.active = false
+		c.IncPC()
+		return
+	}
+	c.waiting = true
+}
+
+func (c *Chip8) opReadDelay() {
+	c.v[c.GetXReg()] = c.delayTimer
+	c.IncPC()
+}
+
+func (c *Chip8) opSetDelay() {
+	c.delayTimer = c.v[c.GetXReg()]
+	c.IncPC()
+}
+
+func (c *Chip8) opSetSound() {
+	c.soundTimer = c.v[c.GetXReg()]
+	c.IncPC()
+}
+
+func (c *Chip8) opAddIndex() {
+	old := c.index
+	c.index += uint16(c.v[c.GetXReg()])
+	if c.variant == variantMegaChip && c.index < old {
+		c.mega.indexHi++ // I is 24 bits wide
+	}
+	if c.quirks.IndexOverflow {
+		c.v[0xF] = 0
+		if c.index > 0xFFF {
+			c.v[0xF] = 1
+		}
+	}
+	c.IncPC()
+}
+
+func (c *Chip8) opFontChar() {
+	// Each glyph is 5 bytes; only the low nibble picks one.
+	c.index = FONT_OFFSET + 5*uint16(c.v[c.GetXReg()]&0xF)
+	c.mega.indexHi = 0
+	c.IncPC()
+}
+
+func (c *Chip8) opBigFontChar() {
+	if c.needs(variantSCHIP) {
+		c.index = BIG_FONT_OFFSET + 10*uint16(c.v[c.GetXReg()]&0xF)
+		c.mega.indexHi = 0
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opSaveFlags() {
+	if c.needs(variantSCHIP) {
+		copy(c.rpl[:c.GetXReg()+1], c.v[:])
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opLoadFlags() {
+	if c.needs(variantSCHIP) {
+		copy(c.v[:c.GetXReg()+1], c.rpl[:])
+		c.IncPC()
+	}
+}
+
+func (c *Chip8) opBCD() {
+	v := c.v[c.GetXReg()]
+	c.writeMem(c.index, v/100)
+	c.writeMem(c.index+1, v/10%10)
+	c.writeMem(c.index+2, v%10)
+	c.IncPC()
+}
+
+func (c *Chip8) opStore() {
+	x := c.GetXReg()
+	for i := uint16(0); i <= x; i++ {
+		c.writeMem(c.index+i, c.v[i])
+		if c.fault != nil {
+			return
+		}
+	}
+	c.advanceIndex(x)
+	c.IncPC()
+}
+
+func (c *Chip8) opRead() {
+	x := c.GetXReg()
+	for i := uint16(0); i <= x; i++ {
+		c.v[i] = c.readMem(c.index + i)
+	}
+	c.advanceIndex(x)
+	c.IncPC()
+}
+
+// advanceIndex moves I past the registers FX55 or FX65 just moved, as far
+// as the load/store quirk says.
+func (c *Chip8) advanceIndex(x uint16) {
+	switch c.quirks.LoadStore {
+	case loadStoreVIP:
+		c.index += x + 1
+	case loadStoreCHIP48:
+		c.index += x
+	}
+}
+
+// printOpcodes writes the instruction reference, keeping only entries whose
+// pattern, mnemonic or summary contains filter.
+func printOpcodes(w io.Writer, filter string) {
+	filter = strings.ToLower(filter)
+	for _, op := range opcodes {
+		line := op.pattern + " " + op.name + " " + op.summary
+		if filter != "" && !strings.Contains(strings.ToLower(line), filter) {
+			continue
+		}
+		fmt.Fprintf(w, "%-6s%-7s%s\n", op.pattern, op.name, op.summary)
+		if op.quirks != "" {
+			fmt.Fprintf(w, "%13squirk: %s\n", "", op.quirks)
+		}
+	}
+}
+
+// runHelp implements "hapax8 help opcodes [pattern]".
+func runHelp(args []string, stdout io.Writer) error {
+	if len(args) < 1 || len(args) > 2 || args[0] != "opcodes" {
+		return errors.New("usage: hapax8 help opcodes [pattern]")
+	}
+	filter := ""
+	if len(args) == 2 {
+		filter = args[1]
+	}
+	printOpcodes(stdout, filter)
+	return nil
+}
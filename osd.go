@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// osdFont fills in the characters the built-in hex font lacks, in its 4x5
+// style: a byte per row, the glyph in the top four bits. 0-9 and A-F come
+// from fontSet itself, and anything in neither is drawn as '?'.
+var osdFont = map[rune][5]uint8{
+	' ':  {0x00, 0x00, 0x00, 0x00, 0x00},
+	'G':  {0xF0, 0x80, 0xB0, 0x90, 0xF0},
+	'H':  {0x90, 0x90, 0xF0, 0x90, 0x90},
+	'I':  {0xE0, 0x40, 0x40, 0x40, 0xE0},
+	'J':  {0x70, 0x20, 0x20, 0xA0, 0xE0},
+	'K':  {0x90, 0xA0, 0xC0, 0xA0, 0x90},
+	'L':  {0x80, 0x80, 0x80, 0x80, 0xF0},
+	'M':  {0x90, 0xF0, 0xF0, 0x90, 0x90},
+	'N':  {0x90, 0xD0, 0xB0, 0x90, 0x90},
+	'O':  {0xF0, 0x90, 0x90, 0x90, 0xF0},
+	'P':  {0xF0, 0x90, 0xF0, 0x80, 0x80},
+	'Q':  {0xF0, 0x90, 0x90, 0xB0, 0xF0},
+	'R':  {0xE0, 0x90, 0xE0, 0xA0, 0x90},
+	'S':  {0xF0, 0x80, 0xF0, 0x10, 0xF0},
+	'T':  {0xE0, 0x40, 0x40, 0x40, 0x40},
+	'U':  {0x90, 0x90, 0x90, 0x90, 0xF0},
+	'V':  {0x90, 0x90, 0x90, 0xA0, 0x40},
+	'W':  {0x90, 0x90, 0xF0, 0xF0, 0x90},
+	'X':  {0x90, 0x90, 0x60, 0x90, 0x90},
+	'Y':  {0xA0, 0xA0, 0x40, 0x40, 0x40},
+	'Z':  {0xF0, 0x10, 0x60, 0x80, 0xF0},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x40},
+	',':  {0x00, 0x00, 0x00, 0x40, 0x80},
+	':':  {0x00, 0x40, 0x00, 0x40, 0x00},
+	'-':  {0x00, 0x00, 0xF0, 0x00, 0x00},
+	'+':  {0x00, 0x40, 0xE0, 0x40, 0x00},
+	'=':  {0x00, 0xF0, 0x00, 0xF0, 0x00},
+	'!':  {0x40, 0x40, 0x40, 0x00, 0x40},
+	'?':  {0xE0, 0x10, 0x60, 0x00, 0x40},
+	'/':  {0x10, 0x10, 0x20, 0x40, 0x80},
+	'%':  {0x90, 0x10, 0x60, 0x80, 0x90},
+	'\'': {0x40, 0x40, 0x00, 0x00, 0x00},
+	'(':  {0x20, 0x40, 0x40, 0x40, 0x20},
+	')':  {0x40, 0x20, 0x20, 0x20, 0x40},
+}
+
+// osdGlyph returns the rows of r's glyph. Letters are drawn upper case.
+func osdGlyph(r rune) []uint8 {
+	r = unicode.ToUpper(r)
+	switch {
+	case r >= '0' && r <= '9':
+		return fontSet[(r-'0')*5:][:5]
+	case r >= 'A' && r <= 'F':
+		return fontSet[(r-'A'+10)*5:][:5]
+	}
+	g, ok := osdFont[r]
+	if !ok {
+		g = osdFont['?']
+	}
+	return g[:]
+}
+
+// OSD cells are a glyph and a pixel of space; the box has a pixel of
+// margin above the text.
+const (
+	osdCellWidth  = 5
+	osdCellHeight = 6
+)
+
+// What each pixel of a rendered OSD mask shows.
+const (
+	osdNone  uint8 = iota // the display
+	osdPaper              // the box behind the text, drawn unlit
+	osdInk                // the text, drawn lit
+)
+
+// osd is the on-screen display: a message boxed over the bottom of the
+// display. It is laid out in display pixels, so every frontend shows it the
+// same way it shows the display, tty included.
+type osd struct {
+	text  string
+	until time.Time // when it goes away; zero to stay until hidden
+	mask  []uint8   // the last render, reused
+}
+
+// show puts text up for d, or until hide if d is 0.
+func (o *osd) show(text string, d time.Duration, now time.Time) {
+	o.text = text
+	o.until = time.Time{}
+	if d > 0 {
+		o.until = now.Add(d)
+	}
+}
+
+func (o *osd) hide() {
+	o.text = ""
+}
+
+// render returns what each pixel of a w x h display shows, osdNone, osdPaper
+// or osdInk, row by row; nil if nothing is up. Text is wrapped at spaces,
+// and lines that don't fit the display are dropped from the top.
+func (o *osd) render(w, h int, now time.Time) []uint8 {
+	if o.text != "" && !o.until.IsZero() && !now.Before(o.until) {
+		o.hide()
+	}
+	if o.text == "" {
+		return nil
+	}
+	lines := osdWrap(o.text, w/osdCellWidth)
+	if n := h / osdCellHeight; len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	if cap(o.mask) < w*h {
+		o.mask = make([]uint8, w*h)
+	}
+	m := o.mask[:w*h]
+	clear(m)
+	top := h - len(lines)*osdCellHeight
+	for i := top * w; i < len(m); i++ {
+		m[i] = osdPaper
+	}
+	for l, line := range lines {
+		for col, r := range []rune(line) {
+			for gy, row := range osdGlyph(r) {
+				for gx := 0; gx < 4; gx++ {
+					if row&(0x80>>gx) != 0 {
+						m[(top+1+l*osdCellHeight+gy)*w+col*osdCellWidth+1+gx] = osdInk
+					}
+				}
+			}
+		}
+	}
+	return m
+}
+
+// osdWrap breaks text into lines of at most cols characters, at spaces
+// where it can.
+func osdWrap(text string, cols int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for len([]rune(word)) > cols {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, string([]rune(word)[:cols]))
+			word = string([]rune(word)[cols:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= cols:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// osdNotice is how long a notice, such as a toggle's new setting, stays up.
+const osdNotice = 1500 * time.Millisecond
+
+// osdCell reports what a frontend draws at pixel x, y of a w-wide display
+// given the mask from render: covered is false where the display shows
+// through, and otherwise on says whether the pixel is text. Covered pixels
+// are drawn white on black, whatever colors the chip gives them.
+func osdCell(mask []uint8, w, x, y int) (on, covered bool) {
+	if mask == nil || mask[y*w+x] == osdNone {
+		return false, false
+	}
+	return mask[y*w+x] == osdInk, true
+}
+
+// toggled puts up a notice that a display setting changed.
+func (o *frontendOpts) toggled(name string, on bool) {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	o.osd.show(name+" "+state, osdNotice, time.Now())
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// paletteHook picks the colors a frame is drawn in. The windowed frontends
+// call it once a frame with the frame number, counting from 1, and draw
+// every color the chip asks for through the function it returns. -colors
+// and -hue-cycle are built from it; other effects only need another hook.
+type paletteHook func(frame uint64) func(color.Color) color.Color
+
+// recolor returns the palette hook's mapping for the frame about to be
+// drawn, or nil if there's no hook.
+func (o *frontendOpts) recolor() func(color.Color) color.Color {
+	o.frames++
+	if o.display.palette == nil {
+		return nil
+	}
+	return o.display.palette(o.frames)
+}
+
+// newPaletteHook returns the hook for -colors and -hue-cycle: colors is
+// "lit,unlit" in #RRGGBB form, replacing white and black, and every color
+// turns once around the color wheel every cycle seconds. It returns nil if
+// neither is set.
+func newPaletteHook(colors string, cycle float64) (paletteHook, error) {
+	var lit, unlit color.Color
+	if colors != "" {
+		l, u, ok := strings.Cut(colors, ",")
+		var err error
+		if lit, err = parseColor(l); err == nil {
+			unlit, err = parseColor(u)
+		}
+		if !ok || err != nil {
+			return nil, fmt.Errorf("bad colors %q (want lit,unlit as #RRGGBB,#RRGGBB)", colors)
+		}
+	}
+	if cycle < 0 {
+		return nil, fmt.Errorf("hue cycle %g is negative", cycle)
+	}
+	if lit == nil && cycle == 0 {
+		return nil, nil
+	}
+	return func(frame uint64) func(color.Color) color.Color {
+		turn := 0.0
+		if cycle > 0 {
+			turn = math.Mod(float64(frame)/60/cycle, 1)
+		}
+		return func(c color.Color) color.Color {
+			if lit != nil {
+				switch c {
+				case color.White:
+					c = lit
+				case color.Black:
+					c = unlit
+				}
+			}
+			if turn != 0 {
+				c = rotateHue(c, turn)
+			}
+			return c
+		}
+	}, nil
+}
+
+// filters are the named palette hooks -filter chains after -colors and
+// -hue-cycle. A file compiled into a custom build can add its own from
+// init with registerFilter, as frontends register themselves, and select
+// it by name without touching main.
+var filters = map[string]paletteHook{
+	"gray": func(uint64) func(color.Color) color.Color { return grayColor },
+}
+
+// registerFilter makes a palette hook selectable with -filter.
+func registerFilter(name string, hook paletteHook) {
+	filters[name] = hook
+}
+
+// filterNames lists the filters compiled into this binary.
+func filterNames() []string {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// grayColor is the "gray" filter: c at its luminance, for telling colors
+// apart by brightness alone.
+func grayColor(c color.Color) color.Color {
+	return color.GrayModel.Convert(c)
+}
+
+// chainFilters follows hook, which may be nil, with the filters named in
+// the comma-separated list names, in order. It returns nil if there's
+// nothing to do.
+func chainFilters(hook paletteHook, names string) (paletteHook, error) {
+	chain := []paletteHook{}
+	if hook != nil {
+		chain = append(chain, hook)
+	}
+	for _, name := range strings.Split(names, ",") {
+		if name == "" {
+			continue
+		}
+		f, ok := filters[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q (have %s)", name, strings.Join(filterNames(), ", "))
+		}
+		chain = append(chain, f)
+	}
+	switch len(chain) {
+	case 0:
+		return nil, nil
+	case 1:
+		return chain[0], nil
+	}
+	return func(frame uint64) func(color.Color) color.Color {
+		maps := make([]func(color.Color) color.Color, len(chain))
+		for i, h := range chain {
+			maps[i] = h(frame)
+		}
+		return func(c color.Color) color.Color {
+			for _, m := range maps {
+				c = m(c)
+			}
+			return c
+		}
+	}, nil
+}
+
+// parseColor parses #RRGGBB.
+func parseColor(s string) (color.Color, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return nil, fmt.Errorf("bad color %q", s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF}, nil
+}
+
+// rotateHue turns c's hue by turn, a fraction of a full circle, keeping
+// its brightness. Grays, white and black included, don't change.
+func rotateHue(c color.Color, turn float64) color.Color {
+	r, g, b, a := c.RGBA()
+	fr, fg, fb := float64(r)/0xFFFF, float64(g)/0xFFFF, float64(b)/0xFFFF
+	cos, sin := math.Cos(2*math.Pi*turn), math.Sin(2*math.Pi*turn)
+	// The luminance-preserving rotation CSS's hue-rotate() filter uses.
+	channel := func(kr, kg, kb float64) uint8 {
+		v := kr*fr + kg*fg + kb*fb
+		return uint8(math.Round(math.Max(0, math.Min(1, v)) * 0xFF))
+	}
+	return color.RGBA{
+		channel(0.213+cos*0.787-sin*0.213, 0.715-cos*0.715-sin*0.715, 0.072-cos*0.072+sin*0.928),
+		channel(0.213-cos*0.213+sin*0.143, 0.715+cos*0.285+sin*0.140, 0.072-cos*0.072-sin*0.283),
+		channel(0.213-cos*0.213-sin*0.787, 0.715-cos*0.715+sin*0.715, 0.072+cos*0.928+sin*0.072),
+		uint8(a >> 8),
+	}
+}
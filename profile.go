@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// stage is one step of producing a frame.
+type stage int
+
+const (
+	stageDecode  stage = iota // fetching and decoding instructions
+	stageExecute              // running them
+	stageDraw                 // turning the display into frontend pixels
+	stagePresent              // handing those pixels to the screen
+	numStages
+)
+
+var stageNames = [numStages]string{"decode", "execute", "draw", "present"}
+
+// profiler collects how long each stage took in every frame. A nil
+// *profiler is valid and records nothing, so callers don't need to check.
+type profiler struct {
+	cur    [numStages]time.Duration
+	frames [numStages][]time.Duration
+}
+
+// start returns the time to measure the first stage from.
+func (p *profiler) start() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// lap adds the time since t to stage s of the current frame and returns the
+// time to measure the next stage from.
+func (p *profiler) lap(s stage, t time.Time) time.Time {
+	if p == nil {
+		return t
+	}
+	now := time.Now()
+	p.cur[s] += now.Sub(t)
+	return now
+}
+
+// endFrame closes the current frame.
+func (p *profiler) endFrame() {
+	if p == nil {
+		return
+	}
+	for s := range p.cur {
+		p.frames[s] = append(p.frames[s], p.cur[s])
+		p.cur[s] = 0
+	}
+}
+
+// report writes the mean and percentiles of each stage's time per frame.
+func (p *profiler) report(w io.Writer) {
+	if p == nil || len(p.frames[0]) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%d frames, time per frame:\n", len(p.frames[0]))
+	fmt.Fprintf(w, "%-8s %10s %10s %10s %10s\n", "stage", "mean", "p50", "p95", "max")
+	for s, times := range p.frames {
+		sorted := append([]time.Duration(nil), times...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		var total time.Duration
+		for _, d := range sorted {
+			total += d
+		}
+		pct := func(q int) time.Duration { return sorted[(len(sorted)-1)*q/100] }
+		fmt.Fprintf(w, "%-8s %10v %10v %10v %10v\n", stageNames[s],
+			total/time.Duration(len(sorted)), pct(50), pct(95), sorted[len(sorted)-1])
+	}
+}
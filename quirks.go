@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// Quirks are the behaviors CHIP-8 interpreters disagree on, which ROMs from
+// different generations were written against. The zero value is what this
+// emulator does by default: the COSMAC VIP's shifts, load/store, jumps and
+// key waits, with sprites clipped and no waiting for the display.
+type Quirks struct {
+	Shift         bool          // 8XY6 and 8XYE shift VX in place rather than VY into VX
+	LoadStore     loadStoreMode // where FX55 and FX65 leave I
+	JumpOffset    bool          // BNNN adds VX, X being the top nibble of NNN, rather than V0
+	IndexOverflow bool          // FX1E sets VF when I passes 0xFFF
+	LogicResetVF  bool          // 8XY1, 8XY2 and 8XY3 reset VF to 0, as on the COSMAC VIP
+	Wrap          bool          // sprites wrap around the display edges rather than being clipped
+	DisplayWait   bool          // DXYN waits for the next frame after drawing, as on the COSMAC VIP
+	KeyPress      bool          // FX0A finishes when the key goes down rather than when it's released
+}
+
+// loadStoreMode selects where FX55 and FX65 leave I, which ROMs written
+// for different interpreters disagree on.
+type loadStoreMode int
+
+const (
+	loadStoreVIP    loadStoreMode = iota // I ends up at I+X+1, as on the COSMAC VIP
+	loadStoreCHIP48                      // I ends up at I+X
+	loadStoreSCHIP                       // I is left unchanged
+)
+
+func parseLoadStoreMode(s string) (loadStoreMode, error) {
+	switch s {
+	case "vip":
+		return loadStoreVIP, nil
+	case "chip48":
+		return loadStoreCHIP48, nil
+	case "schip":
+		return loadStoreSCHIP, nil
+	}
+	return loadStoreVIP, fmt.Errorf("bad load/store quirk %q (want vip, chip48 or schip)", s)
+}
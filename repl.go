@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const replHelp = `Type a mnemonic (LOAD v1 0xAB) or a 4-digit hex opcode (61AB) to run it at PC.
+FX0A asks for the key to press. Commands: screen, reset, help, quit`
+
+// repl runs opcodes typed one per line on a scratch machine, printing the
+// chip state after each and the display whenever it changes.
+func repl(in io.Reader, out io.Writer) {
+	c := new(Chip8)
+	c.trace = io.Discard
+	c.Init()
+	fmt.Fprintln(out, replHelp)
+	sc := bufio.NewScanner(in)
+	var keys Input = replKeys{sc: sc, out: out}
+	for fmt.Fprint(out, "> "); sc.Scan(); fmt.Fprint(out, "> ") {
+		line := strings.TrimSpace(sc.Text())
+		switch strings.ToLower(line) {
+		case "":
+			continue
+		case "quit", "exit":
+			return
+		case "help":
+			fmt.Fprintln(out, replHelp)
+			continue
+		case "reset":
+			c.Init()
+			continue
+		case "screen":
+			printScreen(out, c)
+			continue
+		}
+		op, err := parseOpcode(line)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		if int(c.pc)+1 >= len(c.memory) {
+			fmt.Fprintf(out, "pc %#x leaves no room for an opcode; reset to continue\n", c.pc)
+			continue
+		}
+		before := bytes.Clone(c.gfx)
+		c.memory[c.pc] = uint8(op >> 8)
+		c.memory[c.pc+1] = uint8(op)
+		c.Execute()
+		if c.keyWait.active {
+			// FX0A is waiting: press and release the key asked for.
+			k := keys.WaitKey()
+			c.keys[k] = true
+			c.Execute()
+			c.keys[k] = false
+			if c.keyWait.active {
+				c.Execute()
+			}
+		}
+		fmt.Fprint(out, c.ToString())
+		if c.Halted() {
+			fmt.Fprintln(out, "halted (jump to self); reset to continue")
+		}
+		if err := c.Fault(); err != nil {
+			// Cleared so the next line runs; the state is as the fault left it.
+			fmt.Fprintln(out, "fault:", err)
+			c.fault = nil
+		}
+		if !bytes.Equal(before, c.gfx) {
+			printScreen(out, c)
+		}
+	}
+}
+
+// replKeys is the REPL's keypad. No key is ever held down; when FX0A waits,
+// the key is typed on a line of its own.
+type replKeys struct {
+	sc  *bufio.Scanner
+	out io.Writer
+}
+
+func (r replKeys) Pressed(uint8) bool { return false }
+
+// WaitKey asks until a hex digit is typed, and gives 0 if the input ends.
+func (r replKeys) WaitKey() uint8 {
+	for {
+		fmt.Fprint(r.out, "key (0-F)? ")
+		if !r.sc.Scan() {
+			return 0
+		}
+		if k, err := strconv.ParseUint(strings.TrimSpace(r.sc.Text()), 16, 4); err == nil {
+			return uint8(k)
+		}
+	}
+}
+
+// parseOpcode accepts either a hex opcode, with or without 0x, or a mnemonic.
+func parseOpcode(s string) (uint16, error) {
+	h := strings.TrimPrefix(strings.ToLower(s), "0x")
+	if len(h) == 4 {
+		if op, err := strconv.ParseUint(h, 16, 16); err == nil {
+			return uint16(op), nil
+		}
+	}
+	return assemble(s)
+}
+
+// printScreen draws the display with # for lit pixels.
+func printScreen(out io.Writer, c *Chip8) {
+	w, h := c.DisplaySize()
+	for y := 0; y < h; y++ {
+		var row strings.Builder
+		for x := 0; x < w; x++ {
+			if c.Pixel(x, y) {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		fmt.Fprintln(out, row.String())
+	}
+}
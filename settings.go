@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// settings are the options that change how a ROM runs or looks. They are
+// what a bundle carries, so someone else can reproduce the exact setup.
+type settings struct {
+	Image       bool   `json:"image"`
+	IPF         int    `json:"ipf"`
+	Invert      bool   `json:"invert"`
+	Grid        bool   `json:"grid"`
+	FlashSound  bool   `json:"flash_sound"`
+	ProtectExec bool   `json:"protect_exec"`
+	ProtectLow  string `json:"protect_low"`
+	UnknownOps  string `json:"unknown_ops"`
+	StackDepth  int    `json:"stack_depth"`
+	Variant     string `json:"variant"`
+	Strict      bool   `json:"strict"`
+	StartAddr   uint   `json:"start_addr"`
+	WarnUninit  bool   `json:"warn_uninit"`
+
+	QuirkIndexOverflow bool   `json:"quirk_index_overflow"`
+	QuirkLoadStore     string `json:"quirk_load_store"`
+	QuirkJumpOffset    bool   `json:"quirk_jump_offset"`
+	QuirkShift         bool   `json:"quirk_shift"`
+	QuirkLogicVF       bool   `json:"quirk_logic_vf"`
+	QuirkWrap          bool   `json:"quirk_wrap"`
+	QuirkDisplayWait   bool   `json:"quirk_display_wait"`
+	QuirkKeyPress      bool   `json:"quirk_key_press"`
+
+	Colors   string  `json:"colors"`
+	HueCycle float64 `json:"hue_cycle"`
+	Filters  string  `json:"filters"`
+
+	Keymap   string `json:"keymap"`
+	Keyboard string `json:"keyboard"`
+	Buttons  string `json:"buttons"`
+}
+
+// register binds the settings to command-line flags on fs.
+func (s *settings) register(fs *flag.FlagSet) {
+	fs.BoolVar(&s.Image, "image", false, "load the file as a full memory image at address 0 (implied for 4096-byte files)")
+	fs.IntVar(&s.IPF, "ipf", defaultIPF, "instructions executed per 60Hz frame")
+	fs.BoolVar(&s.Invert, "invert", false, "invert the display colors (toggle with F1)")
+	fs.BoolVar(&s.Grid, "grid", false, "outline each pixel (toggle with F2)")
+	fs.BoolVar(&s.FlashSound, "flash-sound", false, "flash a border while the sound timer is active (toggle with F3)")
+	fs.StringVar(&s.Colors, "colors", "", "draw in these colors instead of white on black: lit,unlit as #RRGGBB,#RRGGBB")
+	fs.StringVar(&s.Filters, "filter", "", "pass the display's colors through these filters, comma-separated, after -colors and -hue-cycle: "+strings.Join(filterNames(), ", "))
+	fs.Float64Var(&s.HueCycle, "hue-cycle", 0, "turn the display's colors around the color wheel once every this many seconds (0 for never); grays don't change, so use it with -colors or CHIP-8X")
+	fs.BoolVar(&s.ProtectExec, "protect-exec", false, "fault if the PC enters the interpreter area below the start address")
+	fs.StringVar(&s.ProtectLow, "protect-low", "off", "writes below the start address: off, log or fault")
+	fs.UintVar(&s.StartAddr, "start-addr", 0, "address programs load and start at: 0x600 for ETI-660 programs; 0 for the variant's usual 0x200, or 0x300 for CHIP-8X")
+	fs.BoolVar(&s.Strict, "strict", false, "turn every check on at its tightest, for ROM authors: implies -protect-exec, -protect-low=fault, -unknown-ops=halt and -warn-uninit, and faults on memory accesses past the end")
+	fs.BoolVar(&s.WarnUninit, "warn-uninit", false, "warn when the ROM reads memory that neither it nor the interpreter has put anything in")
+	fs.StringVar(&s.UnknownOps, "unknown-ops", "skip", "instructions that aren't CHIP-8 opcodes: skip them or halt with a fault")
+	fs.BoolVar(&s.QuirkIndexOverflow, "quirk-index-overflow", false, "FX1E sets VF when I passes 0xFFF, as on the Amiga interpreter")
+	fs.StringVar(&s.QuirkLoadStore, "quirk-load-store", "vip", "where FX55/FX65 leave I: vip (I+X+1), chip48 (I+X) or schip (unchanged)")
+	fs.BoolVar(&s.QuirkJumpOffset, "quirk-jump-offset", false, "BNNN jumps to XNN + VX, as on CHIP-48 and SCHIP, rather than NNN + V0")
+	fs.BoolVar(&s.QuirkShift, "quirk-shift", false, "8XY6/8XYE shift VX in place, as on CHIP-48 and SCHIP, rather than shifting VY into VX")
+	fs.StringVar(&s.Variant, "variant", "chip8", "dialect to run: chip8, schip for SUPER-CHIP 1.1's 128x64 display and extra opcodes, xochip for XO-CHIP, chip8x for the VIP color board, megachip for MegaChip's 256x192 color display, or hires for the VIP's 64x64 two-page hi-res CHIP-8")
+	fs.BoolVar(&s.QuirkLogicVF, "quirk-logic-vf", false, "8XY1/8XY2/8XY3 reset VF to 0, as on the COSMAC VIP")
+	fs.BoolVar(&s.QuirkWrap, "quirk-wrap", false, "sprites wrap around the display edges instead of being clipped")
+	fs.BoolVar(&s.QuirkDisplayWait, "quirk-display-wait", false, "DXYN waits for the next frame, drawing at most one sprite a frame as on the COSMAC VIP")
+	fs.BoolVar(&s.QuirkKeyPress, "quirk-key-press", false, "FX0A finishes as soon as a key is pressed, rather than when it's released as on the COSMAC VIP")
+	fs.IntVar(&s.StackDepth, "stack-depth", defaultStackDepth, "subroutine calls that can be nested; some Octo programs need more than 16")
+	fs.Func("keymap", "put the hex keypad on other keys: entries like 5=up 8=down, or a file of them (see -print-keymap)", keymapFlag(&s.Keymap))
+	fs.Func("buttons", "put the hex keypad on game controller buttons, as -keymap does keys: entries like 5=up 6=a, or a file of them; the D-pad, A and B are 5 7 8 9, 6 and 4 by default", keymapFlag(&s.Buttons))
+	fs.StringVar(&s.Keyboard, "keyboard", "qwerty", "whose labels -keymap and -print-keymap name keys by: "+strings.Join(keyboardNames, ", ")+"; the keys are read by position whatever the layout")
+}
+
+// defaultSettings returns the settings as they are with no flags given.
+func defaultSettings() settings {
+	var s settings
+	s.register(flag.NewFlagSet("defaults", flag.ContinueOnError))
+	return s
+}
+
+// apply configures the chip and frontend options from the settings.
+func (s settings) apply(c *Chip8, opts *frontendOpts) error {
+	protectLow, err := parseProtectMode(s.ProtectLow)
+	if err != nil {
+		return err
+	}
+	unknownOps, err := parseUnknownMode(s.UnknownOps)
+	if err != nil {
+		return err
+	}
+	variant, err := parseVariant(s.Variant)
+	if err != nil {
+		return err
+	}
+	loadStore, err := parseLoadStoreMode(s.QuirkLoadStore)
+	if err != nil {
+		return err
+	}
+	palette, err := s.palette()
+	if err != nil {
+		return err
+	}
+	keys, err := parseKeymap(s.Keymap, s.Keyboard)
+	if err != nil {
+		return err
+	}
+	buttons, err := parseButtons(s.Buttons)
+	if err != nil {
+		return err
+	}
+	if s.StackDepth < 1 || s.StackDepth > maxStackDepth {
+		return fmt.Errorf("stack depth %d out of range 1-%d", s.StackDepth, maxStackDepth)
+	}
+	start := variant.progStart()
+	if s.StartAddr != 0 {
+		if s.StartAddr >= uint(variant.memSize()) {
+			return fmt.Errorf("start address %#x out of range 0x1-%#x", s.StartAddr, variant.memSize()-1)
+		}
+		start = uint16(s.StartAddr)
+	}
+	c.memImage = s.Image
+	c.ipf = s.IPF
+	c.protectExec = s.ProtectExec
+	c.protectLow = protectLow
+	c.unknownOps = unknownOps
+	c.warnUninit = s.WarnUninit
+	if s.Strict {
+		c.protectExec = true
+		c.protectLow = protectFault
+		c.unknownOps = unknownHalt
+		c.warnUninit = true
+	}
+	c.boundsFault = s.Strict
+	c.variant = variant
+	if len(c.memory) != variant.memSize() || c.progStart != start {
+		c.progStart = start
+		c.Init() // start over with memory of the right size; nothing is loaded yet
+	}
+	c.stackDepth = s.StackDepth
+	c.stack = make([]uint16, s.StackDepth)
+	c.quirks = Quirks{
+		Shift:         s.QuirkShift,
+		LoadStore:     loadStore,
+		JumpOffset:    s.QuirkJumpOffset,
+		IndexOverflow: s.QuirkIndexOverflow,
+		LogicResetVF:  s.QuirkLogicVF,
+		Wrap:          s.QuirkWrap,
+		DisplayWait:   s.QuirkDisplayWait,
+		KeyPress:      s.QuirkKeyPress,
+	}
+	opts.display = displayOpts{invert: s.Invert, grid: s.Grid, flash: s.FlashSound, palette: palette}
+	opts.keys = keys
+	opts.buttons = buttons
+	return nil
+}
+
+// palette returns the palette hook for -colors, -hue-cycle and -filter.
+func (s settings) palette() (paletteHook, error) {
+	hook, err := newPaletteHook(s.Colors, s.HueCycle)
+	if err != nil {
+		return nil, err
+	}
+	return chainFilters(hook, s.Filters)
+}
+
+// bundleExt marks a file as a bundle rather than a raw ROM.
+const bundleExt = ".json"
+
+// bundle is a ROM packaged with the settings it should run with. It is
+// stored as JSON, with the ROM base64-encoded.
+type bundle struct {
+	Name     string   `json:"name"`
+	ROM      []byte   `json:"rom"`
+	Settings settings `json:"settings"`
+}
+
+// readROM reads a ROM file, unpacking it if it's a bundle. The bundle is
+// nil for raw ROMs.
+func readROM(path string) ([]byte, *bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filepath.Ext(path) != bundleExt {
+		return data, nil, nil
+	}
+	// Settings missing from the bundle keep their defaults.
+	b := &bundle{Settings: defaultSettings()}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, nil, fmt.Errorf("%s: bad bundle: %v", path, err)
+	}
+	return b.ROM, b, nil
+}
+
+// start resets the chip and loads rom, and b if it came in a bundle, as the
+// command line says: a bundle's settings replace the defaults under the
+// flags given, and otherwise, unless -no-detect, a ROM using another
+// variant's opcodes runs as that variant with its usual quirks. Settings
+// start from the command line afresh each time, so a ROM handed off by
+// another instance gets none of the last one's. It returns the settings
+// the ROM runs with.
+func (o *frontendOpts) start(c *Chip8, rom []byte, b *bundle) (settings, error) {
+	var set settings
+	fs := romFlags(&set, o.args)
+	if b != nil {
+		set = b.Settings
+		fs.Parse(o.args)
+	}
+	c.Init()
+	if err := set.apply(c, o); err != nil {
+		return set, err
+	}
+	c.LoadROM(rom)
+	if o.noDetect {
+		return set, nil
+	}
+	// A bundle says which variant it wants, and flags given on the command
+	// line win over what's detected.
+	if b == nil {
+		if v, at := detectSettings(fs, c.memory[c.progStart:]); v != "" {
+			fmt.Fprintf(diag, "running as %s with its usual quirks: first %s-only opcode at %#x (-no-detect to turn this off)\n", v, v, int(c.progStart)+at)
+			if err := set.apply(c, o); err != nil {
+				return set, err
+			}
+			c.LoadROM(rom)
+		}
+	}
+	if v, at := detectVariant(c.memory[c.progStart:]); v != "" && v != variantNames[c.variant] {
+		fmt.Fprintf(diag, "warning: this ROM looks like it needs %s (first %s-only opcode at %#x); run it with -variant=%s\n", v, v, int(c.progStart)+at, variantFlag(v))
+	}
+	return set, nil
+}
+
+// romFlags parses args, the command line's flags, into set. The flags that
+// aren't settings are accepted and ignored, having been seen to at startup.
+func romFlags(set *settings, args []string) *flag.FlagSet {
+	fs := flag.NewFlagSet("hapax8", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	set.register(fs)
+	flag.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			b, ok := f.Value.(interface{ IsBoolFlag() bool })
+			fs.Var(ignoredFlag(ok && b.IsBoolFlag()), f.Name, f.Usage)
+		}
+	})
+	fs.Parse(args) // checked by flag.Parse at startup
+	return fs
+}
+
+// ignoredFlag is a flag romFlags has no use for; it's true for a boolean
+// one, which takes no value.
+type ignoredFlag bool
+
+func (f ignoredFlag) String() string   { return "" }
+func (f ignoredFlag) Set(string) error { return nil }
+func (f ignoredFlag) IsBoolFlag() bool { return bool(f) }
+
+// runBundle implements "hapax8 bundle": it packs a ROM with the settings
+// given as flags, using the same flags the emulator takes.
+func runBundle(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var s settings
+	s.register(fs)
+	out := fs.String("o", "", "bundle to write (default: the ROM name with "+bundleExt+")")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hapax8 bundle [flags] rom.ch8")
+		fs.PrintDefaults()
+	}
+	// Flags may come after the ROM too, as in "hapax8 bundle rom.ch8 -o x.json".
+	var roms []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		roms = append(roms, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(roms) != 1 {
+		fs.Usage()
+		return errors.New("bundle takes exactly one ROM")
+	}
+	if err := s.apply(new(Chip8), new(frontendOpts)); err != nil {
+		return err
+	}
+	rom := roms[0]
+	data, err := os.ReadFile(rom)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = rom[:len(rom)-len(filepath.Ext(rom))] + bundleExt
+	}
+	// Only a file ending in bundleExt is read back as a bundle.
+	if filepath.Ext(*out) != bundleExt {
+		return fmt.Errorf("bundle %s doesn't end in %s, so it would load as a raw ROM", *out, bundleExt)
+	}
+	if filepath.Clean(*out) == filepath.Clean(rom) {
+		return fmt.Errorf("bundle %s would overwrite the ROM; give another with -o", *out)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(bundle{Name: filepath.Base(rom), ROM: data, Settings: s}); err != nil {
+		return err
+	}
+	return os.WriteFile(*out, buf.Bytes(), 0o644)
+}
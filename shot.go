@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+)
+
+// shotMode selects which ways of stopping -shot-on saves a screenshot for.
+type shotMode int
+
+const (
+	shotOff   shotMode = iota
+	shotFault          // faults only
+	shotStop           // faults, and halts on a jump to self
+)
+
+func parseShotMode(s string) (shotMode, error) {
+	switch s {
+	case "off":
+		return shotOff, nil
+	case "fault":
+		return shotFault, nil
+	case "stop":
+		return shotStop, nil
+	}
+	return shotOff, fmt.Errorf("bad screenshot mode %q (want off, fault or stop)", s)
+}
+
+// stopShot saves the display as a PNG and the machine state as JSON the
+// first time the program stops, so a run nobody was watching can be looked
+// at afterwards instead of pieced together from the log.
+type stopShot struct {
+	mode  shotMode
+	path  string    // the files are path.png and path.json
+	run   *runStamp // what the JSON says produced the run
+	saved bool
+}
+
+// newStopShot sets up mode's shots of the ROM at romPath, named after it in
+// dir and stamped with rom and the settings it runs with. It's nil when mode
+// is shotOff.
+func newStopShot(mode shotMode, dir, romPath string, rom []byte, set settings) *stopShot {
+	if mode == shotOff {
+		return nil
+	}
+	name := strings.TrimSuffix(filepath.Base(romPath), filepath.Ext(romPath))
+	return &stopShot{mode: mode, path: filepath.Join(dir, name), run: newRunStamp(rom, set)}
+}
+
+// runStamp identifies what a run was made with: the build, the ROM and the
+// settings, so whoever checks a saved state can tell a stock run from a
+// modified one. Signing it is deferred: there are no movies or savestates
+// on disk to sign, and no key a submitter couldn't also sign with.
+type runStamp struct {
+	Build          string   `json:"build"` // module version and VCS revision
+	ROM            string   `json:"rom_sha256"`
+	Settings       settings `json:"settings"`
+	SettingsDigest string   `json:"settings_sha256"` // of Settings as JSON
+}
+
+func newRunStamp(rom []byte, set settings) *runStamp {
+	romSum := sha256.Sum256(rom)
+	js, _ := json.Marshal(set) // settings are plain values
+	setSum := sha256.Sum256(js)
+	return &runStamp{
+		Build:          buildID(),
+		ROM:            hex.EncodeToString(romSum[:]),
+		Settings:       set,
+		SettingsDigest: hex.EncodeToString(setSum[:]),
+	}
+}
+
+// buildID describes the running binary: its module version, the commit it
+// was built from and whether the tree had uncommitted changes.
+func buildID() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	id := info.Main.Version
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision":
+			id += " " + s.Value
+		case s.Key == "vcs.modified" && s.Value == "true":
+			id += "+modified"
+		}
+	}
+	return id
+}
+
+// stopState is what the JSON file holds. It's also what -state-format's
+// template formats, once per traced instruction.
+type stopState struct {
+	Reason     string    `json:"reason"` // the fault, or "halted"; empty in the trace
+	Variant    string    `json:"variant"`
+	Inst       uint16    `json:"inst"` // the instruction last run, or about to be in the trace
+	PC         uint16    `json:"pc"`
+	I          uint16    `json:"i"`
+	V          [16]uint8 `json:"v"`
+	Stack      []uint16  `json:"stack"` // return addresses, innermost last
+	DelayTimer uint8     `json:"delay_timer"`
+	SoundTimer uint8     `json:"sound_timer"`
+	Executed   uint64    `json:"executed"`
+	Run        *runStamp `json:"run,omitempty"` // only in -shot-on's file
+}
+
+// check saves the shot if the chip has stopped in a way s.mode covers and
+// it hasn't been saved yet. Errors are only logged.
+func (s *stopShot) check(c *Chip8) {
+	if s.saved {
+		return
+	}
+	var reason string
+	switch {
+	case c.fault != nil:
+		reason = c.fault.Error()
+	case c.halted && s.mode == shotStop:
+		reason = "halted"
+	default:
+		return
+	}
+	s.saved = true
+	if err := s.save(c, reason); err != nil {
+		fmt.Fprintln(diag, "screenshot:", err)
+		return
+	}
+	fmt.Fprintf(diag, "saved the display and state to %s.png and %s.json\n", s.path, s.path)
+}
+
+func (s *stopShot) save(c *Chip8, reason string) error {
+	f, err := os.Create(s.path + ".png")
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, c.Framebuffer()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	state := c.state(reason)
+	state.Run = s.run
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path+".json", append(b, '\n'), 0o644)
+}
+
+// state gathers what stopState reports about c. A state decoded from a
+// binary trace has no stack contents, only its depth, so its Stack is empty.
+func (c *Chip8) state(reason string) stopState {
+	s := stopState{
+		Reason:     reason,
+		Variant:    variantNames[c.variant],
+		Inst:       c.inst,
+		PC:         c.pc,
+		I:          c.index,
+		V:          c.v,
+		Stack:      []uint16{},
+		DelayTimer: c.delayTimer,
+		SoundTimer: c.soundTimer,
+		Executed:   c.executed,
+	}
+	if int(c.sp) <= len(c.stack) {
+		s.Stack = append(s.Stack, c.stack[:c.sp]...)
+	}
+	return s
+}
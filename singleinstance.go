@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// instanceSocket is where the running instance listens for ROMs from later ones.
+func instanceSocket() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("hapax8-%d.sock", os.Getuid()))
+}
+
+// handOff forwards a ROM path to an already running instance. It reports
+// false if no instance is listening.
+func handOff(rom string) bool {
+	conn, err := net.Dial("unix", instanceSocket())
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintln(conn, rom)
+	return err == nil
+}
+
+// listenHandoff accepts ROM paths from later instances and delivers them on
+// the returned channel until the closer is closed.
+func listenHandoff() (<-chan string, io.Closer, error) {
+	path := instanceSocket()
+	// Nobody answered handOff, so anything here is left over from a crash.
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	roms := make(chan string)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			sc := bufio.NewScanner(conn)
+			if sc.Scan() {
+				roms <- sc.Text()
+			}
+			conn.Close()
+		}
+	}()
+	return roms, l, nil
+}
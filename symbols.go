@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// symbolKind is what the analysis thinks an address is.
+type symbolKind int
+
+const (
+	symLabel symbolKind = iota // jump target
+	symSub                     // CALL target
+	symData                    // bytes never reached as code, or an LOADI target outside code
+)
+
+var symbolPrefix = map[symbolKind]string{symLabel: "label", symSub: "sub", symData: "data"}
+
+// symbols maps addresses to names.
+type symbols map[uint16]string
+
+// inferSymbols walks the control flow of a ROM loaded at start and names
+// every subroutine, jump target and data block it finds, e.g. sub_2A4.
+// Indirect jumps (BNNN) can't be followed, so code only reached through
+// them is reported as data.
+func inferSymbols(rom []uint8, start uint16) symbols {
+	end := int(start) + len(rom)
+	code := make([]bool, len(rom))
+	kinds := map[uint16]symbolKind{}
+	inROM := func(addr uint16) bool { return int(addr) >= int(start) && int(addr)+1 < end }
+
+	work := []uint16{start}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		for inROM(pc) && !code[pc-start] {
+			code[pc-start], code[pc-start+1] = true, true
+			op := uint16(rom[pc-start])<<8 | uint16(rom[pc-start+1])
+			next := pc + 2
+			stop := false
+			switch {
+			case op == 0x00EE || op == 0x00FD: // RET, SCHIP exit
+				stop = true
+			case op&0xF000 == 0x1000:
+				kinds[targetAddr(op)] = symLabel
+				work = append(work, targetAddr(op))
+				stop = true
+			case op&0xF000 == 0x2000:
+				kinds[targetAddr(op)] = symSub
+				work = append(work, targetAddr(op))
+			case op&0xF000 == 0xB000:
+				stop = true
+			case op&0xF000 == 0x3000, op&0xF000 == 0x4000,
+				op&0xF00F == 0x5000, op&0xF00F == 0x9000,
+				op&0xF0FF == 0xE09E, op&0xF0FF == 0xE0A1:
+				work = append(work, pc+4)
+			case op&0xF000 == 0xA000:
+				if _, ok := kinds[targetAddr(op)]; !ok {
+					kinds[targetAddr(op)] = symData
+				}
+			}
+			if stop {
+				break
+			}
+			pc = next
+		}
+	}
+
+	syms := symbols{}
+	for addr, kind := range kinds {
+		// A LOADI target that turned out to be code is just an address.
+		if kind == symData && inROM(addr) && code[addr-start] {
+			continue
+		}
+		syms[addr] = fmt.Sprintf("%s_%03X", symbolPrefix[kind], addr)
+	}
+	for i := 0; i < len(rom); i++ {
+		if !code[i] && (i == 0 || code[i-1]) {
+			addr := start + uint16(i)
+			if _, ok := syms[addr]; !ok {
+				syms[addr] = fmt.Sprintf("data_%03X", addr)
+			}
+		}
+	}
+	return syms
+}
+
+// readSymbols reads a symbol file: one "ADDR name" pair per line, address
+// in hex, # starting a comment. A missing file is an empty table.
+func readSymbols(path string) (symbols, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return symbols{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	syms := symbols{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 16)
+		if len(fields) != 2 || err != nil {
+			return nil, fmt.Errorf("%s:%d: want \"ADDR name\"", path, n)
+		}
+		syms[uint16(addr)] = fields[1]
+	}
+	return syms, sc.Err()
+}
+
+// writeSymbols writes syms in address order in the format readSymbols reads.
+func writeSymbols(w io.Writer, syms symbols) error {
+	addrs := make([]int, 0, len(syms))
+	for addr := range syms {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	bw := bufio.NewWriter(w)
+	for _, addr := range addrs {
+		fmt.Fprintf(bw, "%03X %s\n", addr, syms[uint16(addr)])
+	}
+	return bw.Flush()
+}
+
+// runSymbols implements "hapax8 symbols [flags] rom.ch8": it infers names
+// for the ROM and merges them into rom.sym, keeping any names already there
+// so renames made by hand survive re-running the analysis. -variant and
+// -start-addr say where the ROM loads, as for lint.
+func runSymbols(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("symbols", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var set settings
+	set.register(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hapax8 symbols [flags] rom.ch8")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("symbols takes exactly one ROM")
+	}
+	rom, b, err := readROM(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		set = b.Settings
+		flags.Parse(args)
+	}
+	c := new(Chip8)
+	c.trace = io.Discard
+	c.Init()
+	if err := set.apply(c, new(frontendOpts)); err != nil {
+		return err
+	}
+	path := strings.TrimSuffix(flags.Arg(0), filepath.Ext(flags.Arg(0))) + ".sym"
+	syms, err := readSymbols(path)
+	if err != nil {
+		return err
+	}
+	for addr, name := range inferSymbols(rom, c.progStart) {
+		if _, ok := syms[addr]; !ok {
+			syms[addr] = name
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeSymbols(io.MultiWriter(f, stdout), syms); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,5 @@
+CALL 0x206
+LOAD v2 0x2
+JUMP 0x204
+LOAD v1 0x1
+RET
\ No newline at end of file
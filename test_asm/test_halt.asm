@@ -0,0 +1,2 @@
+LOAD v1 0x1
+JUMP 0x202
\ No newline at end of file
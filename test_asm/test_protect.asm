@@ -0,0 +1 @@
+JUMP 0x100
\ No newline at end of file
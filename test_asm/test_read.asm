@@ -1,6 +1,7 @@
 LOADI 0xA
 LOAD v1 0xAB
 STOR v1
+LOADI 0xA
 READ v2
 CLR
 DRAW v3 v2 0x1
\ No newline at end of file
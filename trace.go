@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+)
+
+// isControlFlow reports whether op can send the PC anywhere other than the
+// next instruction: jumps, calls, returns and skips.
+func isControlFlow(op uint16) bool {
+	switch topNibble(op) {
+	case 0x0:
+		return op == 0x00EE
+	case 0x1, 0x2, 0x3, 0x4, 0x5, 0x9, 0xB:
+		return true
+	case 0xE:
+		return bottomByte(op) == 0x9E || bottomByte(op) == 0xA1
+	}
+	return false
+}
+
+// traceSampled reports whether the current instruction goes in the trace.
+// With sampling on, every traceEvery-th instruction is kept along with all
+// control flow, so the path through the program can still be followed.
+func (c *Chip8) traceSampled() bool {
+	return c.traceEvery <= 1 || c.executed%uint64(c.traceEvery) == 0 || isControlFlow(c.inst)
+}
+
+// parseStateFormat parses -state-format, a text/template run on each traced
+// state's stopState in place of ToString's dump. It's tried on an empty
+// state first so a misspelled field is caught before anything runs.
+func parseStateFormat(s string) (*template.Template, error) {
+	t, err := template.New("state").Parse(s)
+	if err == nil {
+		err = t.Execute(io.Discard, stopState{Stack: make([]uint16, defaultStackDepth)})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad state format: %v", err)
+	}
+	return t, nil
+}
+
+// writeState writes a traced state on a line of its own, formatted by
+// -state-format if it was given. A format that fails on a real state, say
+// by indexing past the top of the stack, is reported once and dropped.
+func (c *Chip8) writeState(w io.Writer) {
+	if c.stateFormat != nil {
+		var b bytes.Buffer
+		err := c.stateFormat.Execute(&b, c.state(""))
+		if err == nil {
+			b.WriteByte('\n')
+			w.Write(b.Bytes())
+			return
+		}
+		fmt.Fprintln(diag, "state format:", err)
+		c.stateFormat = nil
+	}
+	fmt.Fprintln(w, c.ToString())
+}
+
+// limitWriter passes writes through until max bytes have been written, then
+// notes the truncation once and drops everything after it.
+type limitWriter struct {
+	w       io.Writer
+	max     int64
+	written int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.written >= l.max {
+		return len(p), nil
+	}
+	if l.written+int64(len(p)) > l.max {
+		l.written = l.max
+		_, err := fmt.Fprintf(l.w, "trace truncated after %d bytes\n", l.max)
+		return len(p), err
+	}
+	l.written += int64(len(p))
+	return l.w.Write(p)
+}
+
+// Binary traces are a gzip stream starting with traceMagic, then one record
+// per traced instruction. Each record is a flags byte and the instruction
+// word, followed only by the state that changed since the previous record:
+//
+//	traceNewPC    pc, when it isn't the previous pc + 2
+//	traceNewIndex index
+//	traceNewSP    sp, one byte
+//	traceNewRegs  a 16-bit mask of changed registers, then their values
+//
+// Multi-byte fields are big-endian.
+const traceMagic = "H8TR\x01"
+
+const (
+	traceNewPC = 1 << iota
+	traceNewIndex
+	traceNewSP
+	traceNewRegs
+)
+
+// traceFlushEvery bounds how much trace a killed process can lose.
+const traceFlushEvery = 4096
+
+// traceState is the part of the chip a trace record describes.
+type traceState struct {
+	inst, pc, index, sp uint16
+	v                   [16]uint8
+}
+
+// binaryTrace writes the compressed trace format.
+type binaryTrace struct {
+	zw      *gzip.Writer
+	prev    traceState
+	records int
+	err     error
+}
+
+func newBinaryTrace(w io.Writer) *binaryTrace {
+	t := &binaryTrace{zw: gzip.NewWriter(w)}
+	_, t.err = t.zw.Write([]byte(traceMagic))
+	t.prev.pc = defaultProgStart - 2
+	return t
+}
+
+// record appends the chip's current state.
+func (t *binaryTrace) record(c *Chip8) {
+	if t.err != nil {
+		return
+	}
+	s := traceState{inst: c.inst, pc: c.pc, index: c.index, sp: c.sp, v: c.v}
+	buf := make([]byte, 3, 40)
+	binary.BigEndian.PutUint16(buf[1:], s.inst)
+	if s.pc != t.prev.pc+2 {
+		buf[0] |= traceNewPC
+		buf = binary.BigEndian.AppendUint16(buf, s.pc)
+	}
+	if s.index != t.prev.index {
+		buf[0] |= traceNewIndex
+		buf = binary.BigEndian.AppendUint16(buf, s.index)
+	}
+	if s.sp != t.prev.sp {
+		buf[0] |= traceNewSP
+		buf = append(buf, uint8(s.sp))
+	}
+	var mask uint16
+	for i := range s.v {
+		if s.v[i] != t.prev.v[i] {
+			mask |= 1 << i
+		}
+	}
+	if mask != 0 {
+		buf[0] |= traceNewRegs
+		buf = binary.BigEndian.AppendUint16(buf, mask)
+		for i := range s.v {
+			if mask&(1<<i) != 0 {
+				buf = append(buf, s.v[i])
+			}
+		}
+	}
+	t.prev = s
+	if _, t.err = t.zw.Write(buf); t.err != nil {
+		return
+	}
+	if t.records++; t.records%traceFlushEvery == 0 {
+		t.err = t.zw.Flush()
+	}
+}
+
+// Close finishes the gzip stream. It doesn't close the underlying writer.
+func (t *binaryTrace) Close() error {
+	if err := t.zw.Close(); t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+// catTrace decodes a binary trace from r and writes it to w in the same
+// text form as the plain trace.
+func catTrace(r io.Reader, w io.Writer, format *template.Template) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(zr)
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != traceMagic {
+		return errors.New("not a hapax8 trace")
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	s := traceState{pc: defaultProgStart - 2}
+	var fixed [3]byte
+	for {
+		if _, err := io.ReadFull(br, fixed[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("trace cut short: %v", err)
+		}
+		flags := fixed[0]
+		s.inst = binary.BigEndian.Uint16(fixed[1:])
+		s.pc += 2
+		read16 := func() uint16 {
+			var b [2]byte
+			if _, e := io.ReadFull(br, b[:]); e != nil && err == nil {
+				err = e
+			}
+			return binary.BigEndian.Uint16(b[:])
+		}
+		if flags&traceNewPC != 0 {
+			s.pc = read16()
+		}
+		if flags&traceNewIndex != 0 {
+			s.index = read16()
+		}
+		if flags&traceNewSP != 0 {
+			b, e := br.ReadByte()
+			s.sp, err = uint16(b), e
+		}
+		if flags&traceNewRegs != 0 {
+			mask := read16()
+			for i := range s.v {
+				if mask&(1<<i) != 0 && err == nil {
+					s.v[i], err = br.ReadByte()
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("trace cut short: %v", err)
+		}
+		c := Chip8{inst: s.inst, pc: s.pc, index: s.index, sp: s.sp, v: s.v, stateFormat: format}
+		c.writeState(bw)
+		format = c.stateFormat
+	}
+}
+
+// runTrace implements "hapax8 trace cat [-state-format format] file".
+func runTrace(args []string, stdout io.Writer) error {
+	usage := errors.New("usage: hapax8 trace cat [-state-format format] trace.h8t")
+	if len(args) == 0 || args[0] != "cat" {
+		return usage
+	}
+	fs := flag.NewFlagSet("trace cat", flag.ContinueOnError)
+	stateFmt := fs.String("state-format", "", stateFormatUsage)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usage
+	}
+	var format *template.Template
+	if *stateFmt != "" {
+		var err error
+		if format, err = parseStateFormat(*stateFmt); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return catTrace(f, stdout, format)
+}
+
+// stateFormatUsage is -state-format's help, in the trace and trace cat alike.
+const stateFormatUsage = "Go template each traced state is written with instead of the usual dump, e.g. '{{.PC}} {{index .V 0}}'; the fields are those of the -shot-on JSON, named as in stopState"
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// variantMode is the CHIP-8 dialect the chip runs. It decides which of the
+// variant-only opcodes below execute rather than being treated as unknown.
+// Each variant up to XO-CHIP has everything the ones before it do; CHIP-8X
+// and hi-res CHIP-8 are branches off plain CHIP-8, and MegaChip one off
+// SCHIP.
+type variantMode int
+
+const (
+	variantCHIP8    variantMode = iota
+	variantSCHIP                // SUPER-CHIP 1.1: hi-res, scrolling, 16x16 sprites, big font, RPL flags
+	variantXOCHIP               // XO-CHIP: SCHIP plus 64KB, two display planes and audio patterns
+	variantCHIP8X               // CHIP-8X: the VIP color board's colors and a second keypad
+	variantMegaChip             // MegaChip: SCHIP plus a 256x192 palette display and 24-bit I
+	variantHires                // the COSMAC VIP's two-page hi-res CHIP-8: a 64x64 display
+)
+
+// variantNames are the names variantOps and the warnings use;
+// variantFlags are the -variant values that select them.
+var (
+	variantNames = [...]string{"CHIP-8", "SCHIP", "XO-CHIP", "CHIP-8X", "MegaChip", "Hi-res CHIP-8"}
+	variantFlags = [...]string{"chip8", "schip", "xochip", "chip8x", "megachip", "hires"}
+)
+
+// variantFlag returns the -variant value for a name in variantNames.
+func variantFlag(name string) string {
+	for v, n := range variantNames {
+		if n == name {
+			return variantFlags[v]
+		}
+	}
+	return ""
+}
+
+// parseVariant parses the -variant flag value.
+func parseVariant(s string) (variantMode, error) {
+	for v, name := range variantFlags {
+		if s == name {
+			return variantMode(v), nil
+		}
+	}
+	return variantCHIP8, fmt.Errorf("bad variant %q (want chip8, schip, xochip, chip8x, megachip or hires)", s)
+}
+
+// memSize is how much memory the variant addresses.
+func (v variantMode) memSize() int {
+	switch v {
+	case variantXOCHIP:
+		return xoMemSize
+	case variantMegaChip:
+		return megaMemSize
+	}
+	return memSize
+}
+
+// progStart is where the variant's programs load, unless -start-addr says
+// otherwise. The CHIP-8X interpreter needed the page below 0x300.
+func (v variantMode) progStart() uint16 {
+	if v == variantCHIP8X {
+		return 0x300
+	}
+	return defaultProgStart
+}
+
+// has reports whether v includes the opcodes variant w added.
+func (v variantMode) has(w variantMode) bool {
+	switch {
+	case v == w || w == variantCHIP8:
+		return true
+	case v == variantCHIP8X || w == variantCHIP8X, v == variantHires || w == variantHires:
+		return false
+	case v == variantMegaChip:
+		return w == variantSCHIP
+	case w == variantMegaChip:
+		return false
+	}
+	return v >= w
+}
+
+// needs reports whether the current instruction, added by variant v, should
+// run. Under an earlier variant it is handled as it was before v existed: a
+// 0NNN machine code call is ignored, anything else is unknown.
+func (c *Chip8) needs(v variantMode) bool {
+	if c.variant.has(v) {
+		return true
+	}
+	if c.inst&0xF000 == 0 {
+		c.opSys()
+	} else {
+		c.unknownOpcode()
+	}
+	return false
+}
+
+// variantOps are opcodes that only later CHIP-8 variants define. Finding
+// them in a ROM is a strong hint it was written for that variant.
+var variantOps = []struct {
+	variant  string
+	mask, op uint16
+	what     string
+}{
+	{"SCHIP", 0xFFF0, 0x00C0, "scroll down N"},
+	{"SCHIP", 0xFFFF, 0x00FB, "scroll right"},
+	{"SCHIP", 0xFFFF, 0x00FC, "scroll left"},
+	{"SCHIP", 0xFFFF, 0x00FD, "exit"},
+	{"SCHIP", 0xFFFF, 0x00FE, "lo-res"},
+	{"SCHIP", 0xFFFF, 0x00FF, "hi-res"},
+	{"SCHIP", 0xF0FF, 0xF030, "large font"},
+	{"SCHIP", 0xF0FF, 0xF075, "save RPL flags"},
+	{"SCHIP", 0xF0FF, 0xF085, "load RPL flags"},
+	{"XO-CHIP", 0xFFF0, 0x00D0, "scroll up N"},
+	{"XO-CHIP", 0xF00F, 0x5002, "save Vx..Vy"},
+	{"XO-CHIP", 0xF00F, 0x5003, "load Vx..Vy"},
+	{"XO-CHIP", 0xFFFF, 0xF000, "long index load"},
+	{"XO-CHIP", 0xF0FF, 0xF001, "plane select"},
+	{"XO-CHIP", 0xFFFF, 0xF002, "audio pattern"},
+	{"XO-CHIP", 0xF0FF, 0xF03A, "pitch"},
+	{"CHIP-8X", 0xFFFF, 0x02A0, "background color"},
+	{"CHIP-8X", 0xF00F, 0x5001, "nibble add"},
+	{"CHIP-8X", 0xF0FF, 0xE0F2, "second keypad down"},
+	{"CHIP-8X", 0xF0FF, 0xE0F5, "second keypad up"},
+	{"MegaChip", 0xFFFF, 0x0010, "MegaChip mode off"},
+	{"MegaChip", 0xFFFF, 0x0011, "MegaChip mode on"},
+	// MegaChip's 01NN to 09NN aren't listed: sprite data is full of them.
+}
+
+// minVariantHits is how many variant-only opcodes must turn up before we
+// believe it; a single match is often just sprite or table data.
+const minVariantHits = 2
+
+// detectVariant scans prog for variant-only opcodes and returns the variant
+// with the most hits along with the offset of its first one. It returns ""
+// when the ROM looks like plain CHIP-8. Hi-res CHIP-8 ROMs are known instead
+// by the jump they start with.
+func detectVariant(prog []uint8) (variant string, first int) {
+	if len(prog) >= 2 && uint16(prog[0])<<8|uint16(prog[1]) == hiresEntry {
+		return variantNames[variantHires], 0
+	}
+	hits := map[string]int{}
+	firsts := map[string]int{}
+	for i := 0; i+1 < len(prog); i += 2 {
+		op := uint16(prog[i])<<8 | uint16(prog[i+1])
+		for _, v := range variantOps {
+			if op&v.mask == v.op {
+				if hits[v.variant] == 0 {
+					firsts[v.variant] = i
+				}
+				hits[v.variant]++
+				break
+			}
+		}
+	}
+	best := 0
+	for v, n := range hits {
+		if n >= minVariantHits && (n > best || n == best && v < variant) {
+			variant, best = v, n
+		}
+	}
+	return variant, firsts[variant]
+}
+
+// variantQuirks are the quirk flags most ROMs for each later variant were
+// written against, which detection sets along with -variant.
+var variantQuirks = map[string][]string{
+	"SCHIP":    {"quirk-shift=true", "quirk-load-store=schip", "quirk-jump-offset=true"},
+	"XO-CHIP":  {"quirk-wrap=true"},
+	"MegaChip": {"quirk-shift=true", "quirk-load-store=schip", "quirk-jump-offset=true"},
+}
+
+// detectSettings sets -variant on fs to the variant prog looks like it
+// needs, and the quirks that go with it, leaving alone any flag given on the
+// command line. It returns the variant and the offset of its first opcode,
+// or "" if the ROM looks like plain CHIP-8 or -variant was given.
+func detectSettings(fs *flag.FlagSet, prog []uint8) (variant string, first int) {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	variant, first = detectVariant(prog)
+	if variant == "" || given["variant"] {
+		return "", 0
+	}
+	fs.Set("variant", variantFlag(variant))
+	for _, q := range variantQuirks[variant] {
+		name, value, _ := strings.Cut(q, "=")
+		if !given[name] {
+			fs.Set(name, value)
+		}
+	}
+	return variant, first
+}
+
+// unsupportedUse is what the chip remembers about an opcode it couldn't
+// execute faithfully.
+type unsupportedUse struct {
+	count uint64
+	first uint16 // address it was first seen at
+}
+
+// noteUnsupported records that the current instruction was skipped or
+// ignored rather than executed.
+func (c *Chip8) noteUnsupported() {
+	if c.unsupported == nil {
+		c.unsupported = make(map[uint16]*unsupportedUse)
+	}
+	u := c.unsupported[c.inst]
+	if u == nil {
+		u = &unsupportedUse{first: c.pc}
+		c.unsupported[c.inst] = u
+	}
+	u.count++
+}
+
+// ErrUnknownOpcode is the fault an instruction no opcode matches stops the
+// program with under -unknown-ops=halt.
+type ErrUnknownOpcode struct {
+	PC   uint16 // address of the instruction
+	Inst uint16 // the instruction word
+}
+
+func (e *ErrUnknownOpcode) Error() string {
+	return fmt.Sprintf("unknown opcode %04X at %#x", e.Inst, e.PC)
+}
+
+// unknownOpcode handles an instruction that isn't in the opcode table. It
+// is always recorded for the exit summary; by default the PC then moves past
+// it, since leaving it in place would run the same instruction forever.
+func (c *Chip8) unknownOpcode() {
+	c.noteUnsupported()
+	if c.unknownOps == unknownHalt {
+		c.fault = &ErrUnknownOpcode{PC: c.pc, Inst: c.inst}
+		return
+	}
+	c.IncPC()
+}
+
+// maxUnsupportedLines bounds the exit summary; a ROM that runs into its
+// data can produce hundreds of distinct junk opcodes.
+const maxUnsupportedLines = 8
+
+// reportUnsupported writes a summary of the opcodes the ROM used that the
+// chip skipped or ignored, naming the variant that defines them where known.
+// It writes nothing if there weren't any.
+func (c *Chip8) reportUnsupported(w io.Writer) {
+	ops := make([]uint16, 0, len(c.unsupported))
+	for op := range c.unsupported {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return c.unsupported[ops[i]].first < c.unsupported[ops[j]].first
+	})
+	for i, op := range ops {
+		if i == maxUnsupportedLines {
+			fmt.Fprintf(w, "ROM used %d more unsupported opcodes\n", len(ops)-i)
+			break
+		}
+		u := c.unsupported[op]
+		hint := "not a CHIP-8 opcode"
+		switch {
+		case op == 0:
+			hint = "zeroed memory"
+		case op&0xF000 == 0 && c.variant == variantMegaChip:
+			hint = lookupOpcode(op).summary // ignored, or MegaChip's sound and blending
+		case op&0xF000 == 0:
+			hint = "machine code call, ignored"
+		}
+		for _, v := range variantOps {
+			if op&v.mask == v.op {
+				hint = v.variant + " " + v.what + "; not part of " + variantNames[c.variant]
+				break
+			}
+		}
+		fmt.Fprintf(w, "ROM used %04X (%s) %d times, first at %#x\n", op, hint, u.count, u.first)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchInterval is how often -watch looks at the bundle for changes.
+const watchInterval = time.Second
+
+// restartText is up while a change to the bundle waits for F5.
+const restartText = "settings changed: F5 restarts the ROM with them"
+
+// bundleWatch follows the bundle a ROM was run from for -watch, so its
+// settings can be tweaked while the ROM runs. Changes that can take effect
+// at once do; the rest wait for a restart.
+type bundleWatch struct {
+	path    string
+	modTime time.Time
+	next    time.Time // when to look at the file again
+	flags   func(settings) settings
+
+	loaded  settings // as last read, with the command line's flags over them
+	running settings // what the chip was started with
+	rom     []byte
+
+	pending    bool // loaded or pendingROM differ from what's running in a way only a restart can take up
+	pendingROM []byte
+}
+
+// newBundleWatch starts watching the bundle at path, which the chip is
+// running rom from with set. flags puts the command line's flags back over
+// a bundle's settings, as they were when it was first loaded.
+func newBundleWatch(path string, rom []byte, set settings, flags func(settings) settings) *bundleWatch {
+	w := &bundleWatch{path: path, flags: flags, loaded: set, running: set, rom: rom}
+	if info, err := os.Stat(path); err == nil {
+		w.modTime = info.ModTime()
+	}
+	return w
+}
+
+// fixed is s without the settings a running ROM can change to, leaving
+// those that need a restart to compare.
+func (s settings) fixed() settings {
+	s.IPF = 0
+	s.Invert, s.Grid, s.FlashSound = false, false, false
+	s.Colors, s.HueCycle, s.Filters = "", 0, ""
+	s.Keymap, s.Keyboard, s.Buttons = "", "", ""
+	return s
+}
+
+// reloadLive makes the changes from old to s that can take effect while a
+// ROM runs: its speed, colors, filters, keys and buttons, and the display toggles the file
+// changed, so ones flipped with F1-F3 since stay as they are.
+func (s settings) reloadLive(old settings, c *Chip8, o *frontendOpts) error {
+	palette, err := s.palette()
+	if err != nil {
+		return err
+	}
+	keys, err := parseKeymap(s.Keymap, s.Keyboard)
+	if err != nil {
+		return err
+	}
+	buttons, err := parseButtons(s.Buttons)
+	if err != nil {
+		return err
+	}
+	c.ipf = s.IPF
+	o.display.palette = palette
+	o.keys = keys
+	o.buttons = buttons
+	if s.Invert != old.Invert {
+		o.display.invert = s.Invert
+	}
+	if s.Grid != old.Grid {
+		o.display.grid = s.Grid
+	}
+	if s.FlashSound != old.FlashSound {
+		o.display.flash = s.FlashSound
+	}
+	return nil
+}
+
+// pollWatch rereads the bundle if it has changed. It reports whether the
+// change needs a restart, which restart then makes; until then a prompt
+// stays up. Frontends call it once per loop.
+func (o *frontendOpts) pollWatch(c *Chip8, now time.Time) bool {
+	w := o.watch
+	if w == nil || now.Before(w.next) {
+		return false
+	}
+	w.next = now.Add(watchInterval)
+	info, err := os.Stat(w.path)
+	if err != nil || info.ModTime().Equal(w.modTime) {
+		return false
+	}
+	w.modTime = info.ModTime()
+	rom, b, err := readROM(w.path)
+	if err == nil && b == nil {
+		err = fmt.Errorf("%s isn't a bundle", w.path)
+	}
+	if err == nil {
+		set := w.flags(b.Settings)
+		if err = set.reloadLive(w.loaded, c, o); err == nil {
+			w.loaded = set
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(diag, "not reloaded:", err)
+		o.osd.show("not reloaded: "+err.Error(), osdNotice, now)
+		return false
+	}
+	w.pending = w.loaded.fixed() != w.running.fixed() || !bytes.Equal(rom, w.rom)
+	w.pendingROM = rom
+	if w.pending {
+		fmt.Fprintln(diag, "reloaded", w.path+"; some changes need a restart")
+		o.osd.show(restartText, 0, now)
+		return true
+	}
+	fmt.Fprintln(diag, "reloaded", w.path)
+	o.osd.show("settings reloaded", osdNotice, now)
+	return false
+}
+
+// restart starts the ROM over with the bundle's settings, if a change to it
+// is waiting for one.
+func (o *frontendOpts) restart(c *Chip8) {
+	w := o.watch
+	if w == nil || !w.pending {
+		return
+	}
+	if err := w.loaded.apply(c, o); err != nil {
+		fmt.Fprintln(diag, "not restarted:", err)
+		o.osd.show("not restarted: "+err.Error(), osdNotice, time.Now())
+		return
+	}
+	c.Init()
+	c.LoadROM(w.pendingROM)
+	w.running, w.rom, w.pending = w.loaded, w.pendingROM, false
+	o.osd.show("restarted", osdNotice, time.Now())
+}